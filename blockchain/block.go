@@ -2,6 +2,7 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"time"
 
@@ -54,13 +55,21 @@ func Deserialize(data []byte) *Block {
 }
 
 func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
+	block, _ := CreateBlockContext(context.Background(), txs, prevHash, height)
+	return block
+}
+
+func CreateBlockContext(ctx context.Context, txs []*Transaction, prevHash []byte, height int) (*Block, error) {
 	block := &Block{[]byte{}, txs, prevHash, 0, time.Now().Unix(), height}
 	pow := NewProof(block)
-	nonce, hash := pow.Run()
+	nonce, hash, err := pow.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	block.Hash = hash
 	block.Nonce = nonce
-	return block
+	return block, nil
 }
 
 func Genesis(coinbase *Transaction) *Block {
@@ -2,13 +2,13 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
-	"runtime"
 
 	"github.com/FG420/go-block/handlers"
 	"github.com/dgraph-io/badger"
@@ -19,47 +19,153 @@ type BlockChain struct {
 	Database *badger.DB
 }
 
-func (bc *BlockChain) AddBlock(block *Block) {
+var (
+	ErrOrphanBlock = errors.New("parent block not found")
+	ErrChainExists = errors.New("blockchain already exists")
+	ErrNoChain     = errors.New("no existing blockchain found, create one")
+	ErrStaleTip    = errors.New("chain tip moved while mining")
+)
+
+func (bc *BlockChain) HasBlock(blockHash []byte) bool {
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(blockHash)
+		return err
+	})
+	return err == nil
+}
+
+// AddBlock stores a block received from a peer and moves the tip if the block
+// extends a longer chain. Blocks whose parent isn't stored yet are rejected
+// with ErrOrphanBlock.
+func (bc *BlockChain) AddBlock(block *Block) error {
 	var lastHash []byte
 	var lastBlockData []byte
+	var connected, disconnected []*Block
 
 	err := bc.Database.Update(func(txn *badger.Txn) error {
 		if _, err := txn.Get(block.Hash); err == nil {
 			return nil
 		}
 
+		if len(block.PrevHash) > 0 {
+			if _, err := txn.Get(block.PrevHash); err == badger.ErrKeyNotFound {
+				return ErrOrphanBlock
+			} else if err != nil {
+				return err
+			}
+		}
+
 		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		handlers.HandleErr(err)
+		if err := txn.Set(block.Hash, blockData); err != nil {
+			return err
+		}
 
 		item, err := txn.Get([]byte("lh"))
-		item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
-
-		item, err = txn.Get([]byte(lastHash))
-		handlers.HandleErr(err)
+		if err != nil {
+			return err
+		}
+		lastHash, err = item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
 
-		item.Value(func(val []byte) error {
-			lastBlockData = val
-			return nil
-		})
+		item, err = txn.Get(lastHash)
+		if err != nil {
+			return err
+		}
+		lastBlockData, err = item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
 
 		lastBlock := Deserialize(lastBlockData)
 
 		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			handlers.HandleErr(err)
+			connected, disconnected, err = fork(txn, lastBlock, block)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set([]byte("lh"), block.Hash); err != nil {
+				return err
+			}
 			bc.LastHash = block.Hash
 		}
 
 		return nil
 	})
-	handlers.HandleErr(err)
+	if err != nil {
+		return err
+	}
+
+	recordReorg(len(disconnected))
+	if len(disconnected) > 0 {
+		chainLog.Warn("chain reorganized", "disconnected", len(disconnected), "connected", len(connected),
+			"tip", hex.EncodeToString(block.Hash), "height", block.Height)
+	}
+	for _, b := range disconnected {
+		publishDisconnected(b)
+	}
+	for _, b := range connected {
+		publishConnected(b)
+	}
+
+	return nil
+}
+
+func readBlock(txn *badger.Txn, hash []byte) (*Block, error) {
+	item, err := txn.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return Deserialize(data), nil
+}
+
+// fork walks back from the old and the new tip to their common ancestor. It
+// returns the blocks the new tip connects, oldest first, and the blocks it
+// disconnects from the old chain, newest first.
+func fork(txn *badger.Txn, oldTip, newTip *Block) (connected, disconnected []*Block, err error) {
+	oldBlock, newBlock := oldTip, newTip
+
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		if newBlock.Height >= oldBlock.Height {
+			connected = append([]*Block{newBlock}, connected...)
+			if len(newBlock.PrevHash) == 0 {
+				break
+			}
+			if newBlock, err = readBlock(txn, newBlock.PrevHash); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			disconnected = append(disconnected, oldBlock)
+			if len(oldBlock.PrevHash) == 0 {
+				break
+			}
+			if oldBlock, err = readBlock(txn, oldBlock.PrevHash); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return connected, disconnected, nil
 }
 
 func (bc *BlockChain) MineBlock(txs []*Transaction) *Block {
+	block, err := bc.MineBlockContext(context.Background(), txs)
+	handlers.HandleErr(err)
+
+	return block
+}
+
+// MineBlockContext mines txs on top of the current tip. The block is only
+// written once its proof of work is found, so cancelling ctx leaves the
+// database untouched. If another block became the tip meanwhile the mined
+// block is dropped with ErrStaleTip.
+func (bc *BlockChain) MineBlockContext(ctx context.Context, txs []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastBlockData []byte
 	var lastHeight int
@@ -75,7 +181,7 @@ func (bc *BlockChain) MineBlock(txs []*Transaction) *Block {
 		handlers.HandleErr(err)
 
 		err = item.Value(func(val []byte) error {
-			lastHash = val
+			lastHash = append([]byte{}, val...)
 			return err
 		})
 		handlers.HandleErr(err)
@@ -95,20 +201,41 @@ func (bc *BlockChain) MineBlock(txs []*Transaction) *Block {
 	})
 	handlers.HandleErr(err)
 
-	newBlock := CreateBlock(txs, lastHash, lastHeight+1)
+	newBlock, err := CreateBlockContext(ctx, txs, lastHash, lastHeight+1)
+	if err != nil {
+		return nil, err
+	}
 
 	err = bc.Database.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("lh"))
+		if err != nil {
+			return err
+		}
+		tip, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tip, lastHash) {
+			return ErrStaleTip
+		}
+
 		err = txn.Set(newBlock.Hash, newBlock.Serialize())
 		handlers.HandleErr(err)
 
 		err = txn.Set([]byte("lh"), newBlock.Hash)
 		bc.LastHash = newBlock.Hash
 
-		return nil
+		return err
 	})
+	if errors.Is(err, ErrStaleTip) {
+		chainLog.Info("dropping block mined on a stale tip", "hash", hex.EncodeToString(newBlock.Hash), "height", newBlock.Height)
+		return nil, err
+	}
 	handlers.HandleErr(err)
+	chainLog.Info("block mined", "hash", hex.EncodeToString(newBlock.Hash), "height", newBlock.Height, "txs", len(txs))
+	publishConnected(newBlock)
 
-	return newBlock
+	return newBlock, nil
 }
 
 func (bc *BlockChain) GetBlock(blockHash []byte) (*Block, error) {
@@ -203,6 +330,8 @@ func (bc *BlockChain) FindUTxO() map[string]TxOutputs {
 
 				outs := utxo[txID]
 				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx)
+				outs.Height = block.Height
 				utxo[txID] = outs
 			}
 
@@ -222,9 +351,89 @@ func (bc *BlockChain) FindUTxO() map[string]TxOutputs {
 	return utxo
 }
 
+// HistoryEntry is a confirmed transaction that pays to or spends from an
+// address, with the amounts it moved for that address.
+type HistoryEntry struct {
+	TxID      []byte
+	BlockHash []byte
+	Height    int
+	Received  int
+	Sent      int
+}
+
+// AddressHistory returns the confirmed transactions touching pubKeyHash,
+// newest first.
+func (bc *BlockChain) AddressHistory(pubKeyHash []byte) []HistoryEntry {
+	var blocks []*Block
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+		blocks = append(blocks, block)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	// Walk from genesis so every spent output has been seen before the input
+	// spending it.
+	owned := make(map[string]int)
+	var history []HistoryEntry
+	for i := len(blocks) - 1; i >= 0; i-- {
+		for _, tx := range blocks[i].Transactions {
+			entry := HistoryEntry{TxID: tx.ID, BlockHash: blocks[i].Hash, Height: blocks[i].Height}
+
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					key := fmt.Sprintf("%x:%d", in.ID, in.Out)
+					if value, ok := owned[key]; ok {
+						entry.Sent += value
+						delete(owned, key)
+					}
+				}
+			}
+			for outIdx, out := range tx.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					entry.Received += out.Value
+					owned[fmt.Sprintf("%x:%d", tx.ID, outIdx)] = out.Value
+				}
+			}
+
+			if entry.Received > 0 || entry.Sent > 0 {
+				history = append(history, entry)
+			}
+		}
+	}
+
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history
+}
+
+// UsedPubKeyHashes returns the hex encoded public key hashes that any output
+// in the chain pays to, spent or not.
+func (bc *BlockChain) UsedPubKeyHashes() map[string]bool {
+	used := make(map[string]bool)
+	iter := bc.Iterator()
+
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Outputs {
+				used[hex.EncodeToString(out.PubKeyHash)] = true
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return used
+}
+
 func (bc *BlockChain) FindTransaction(id []byte) (Transaction, error) {
 	iter := bc.Iterator()
-	log.Println(" id ->", id)
 
 	for {
 		block := iter.Next()
@@ -242,18 +451,38 @@ func (bc *BlockChain) FindTransaction(id []byte) (Transaction, error) {
 	return Transaction{}, errors.New("Transaction doesn't exist")
 }
 
+// LocateTransaction finds a confirmed transaction together with the block
+// that includes it.
+func (bc *BlockChain) LocateTransaction(id []byte) (*Transaction, *Block, error) {
+	iter := bc.Iterator()
+
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, id) {
+				return tx, block, nil
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil, nil, errors.New("Transaction doesn't exist")
+}
+
 func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
 	prevTxs := make(map[string]Transaction)
 
 	for _, in := range tx.Inputs {
-		log.Println(in.ID)
 		prevTx, err := bc.FindTransaction(in.ID)
 		handlers.HandleErr(err)
 		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
 	}
 
-	log.Println("transaction Signed")
 	tx.Sign(privKey, prevTxs)
+	chainLog.Debug("transaction signed", "txid", hex.EncodeToString(tx.ID), "inputs", len(tx.Inputs))
 }
 
 func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
@@ -264,7 +493,9 @@ func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 
 	for _, in := range tx.Inputs {
 		prevTx, err := bc.FindTransaction(in.ID)
-		handlers.HandleErr(err)
+		if err != nil {
+			return false
+		}
 		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
 	}
 
@@ -281,26 +512,32 @@ func DeserializeTransaction(data []byte) Transaction {
 	return tx
 }
 
-func InitBlockChain(addr, nodeId string) *BlockChain {
+func dbOptions(path string) badger.Options {
+	opt := badger.DefaultOptions(handlers.DbPath)
+	opt.Dir = path
+	opt.ValueDir = path
+	opt.Logger = dbLogger{chainLog.With("component", "db")}
+
+	return opt
+}
+
+func InitBlockChain(addr, nodeId string) (*BlockChain, error) {
 	path := fmt.Sprintf(handlers.DbPath, nodeId)
 
 	if handlers.DbExist(path) {
-		fmt.Println("Blockchain already exists")
-		runtime.Goexit()
+		return nil, ErrChainExists
 	}
 
-	opt := badger.DefaultOptions(handlers.DbPath)
-	opt.Dir = path
-	opt.ValueDir = path
-
-	db, err := handlers.OpenDB(path, opt)
-	handlers.HandleErr(err)
+	db, err := handlers.OpenDB(path, dbOptions(path))
+	if err != nil {
+		return nil, err
+	}
 
 	var lastHash []byte
 	err = db.Update(func(txn *badger.Txn) error {
 		cbtx := CoinbaseTx(addr, handlers.GenesisData)
 		genesis := Genesis(cbtx)
-		log.Println("Genesis created")
+		chainLog.Info("genesis block created", "hash", hex.EncodeToString(genesis.Hash), "path", path)
 		err = txn.Set(genesis.Hash, genesis.Serialize())
 		handlers.HandleErr(err)
 		err = txn.Set([]byte("lh"), genesis.Hash)
@@ -308,31 +545,32 @@ func InitBlockChain(addr, nodeId string) *BlockChain {
 		lastHash = genesis.Hash
 		return err
 	})
-	handlers.HandleErr(err)
+	if err != nil {
+		handlers.CloseDB(db)
+		return nil, err
+	}
 	blockchain := BlockChain{lastHash, db}
-	log.Println("Path -> ", path)
 
-	return &blockchain
+	return &blockchain, nil
 }
 
-func ContinueBlockChain(nodeId string) *BlockChain {
+func ContinueBlockChain(nodeId string) (*BlockChain, error) {
 	path := fmt.Sprintf(handlers.DbPath, nodeId)
 	if !handlers.DbExist(path) {
-		fmt.Println("No existring blockchain found, create one!")
-		runtime.Goexit()
+		return nil, ErrNoChain
 	}
 
-	opt := badger.DefaultOptions(handlers.DbPath)
-	opt.Dir = path
-	opt.ValueDir = path
-
-	db, err := handlers.OpenDB(path, opt)
-	handlers.HandleErr(err)
+	db, err := handlers.OpenDB(path, dbOptions(path))
+	if err != nil {
+		return nil, err
+	}
 
 	var lastHash []byte
 	err = db.Update(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("lh"))
-		handlers.HandleErr(err)
+		if err != nil {
+			return err
+		}
 
 		err = item.Value(func(val []byte) error {
 			lastHash = append(lastHash, val...)
@@ -340,8 +578,33 @@ func ContinueBlockChain(nodeId string) *BlockChain {
 		})
 		return err
 	})
-	handlers.HandleErr(err)
+	if err != nil {
+		handlers.CloseDB(db)
+		return nil, err
+	}
 
 	chain := BlockChain{lastHash, db}
-	return &chain
+	utxoSet := UTXOSet{&chain}
+	utxoSet.Upgrade()
+
+	return &chain, nil
+}
+
+// Close closes the database and releases the node's lock on it.
+func (chain *BlockChain) Close() {
+	if err := handlers.CloseDB(chain.Database); err != nil {
+		chainLog.Error("closing database failed", "err", err)
+	}
+}
+
+// RepairBlockChain clears the locks left by a node that did not shut down
+// cleanly and truncates the value log to its last consistent entry. force
+// clears the lock even if its pid looks alive.
+func RepairBlockChain(nodeId string, force bool) error {
+	path := fmt.Sprintf(handlers.DbPath, nodeId)
+	if !handlers.DbExist(path) {
+		return fmt.Errorf("no blockchain found in %s", path)
+	}
+
+	return handlers.RepairDB(path, dbOptions(path), force)
 }
@@ -0,0 +1,244 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// bnbMaxTries bounds the branch and bound search, which is exponential in the
+// number of outputs.
+const bnbMaxTries = 100000
+
+var (
+	ErrInsufficientFunds = errors.New("not enough funds")
+	ErrNoExactMatch      = errors.New("no inputs add up to the exact amount")
+	ErrUnknownInput      = errors.New("input is not an unspent output of the address")
+	ErrDuplicateInput    = errors.New("input is given twice")
+
+	// DefaultCoinSelector looks for inputs that need no change and takes the
+	// largest outputs when there are none.
+	DefaultCoinSelector CoinSelector = BranchAndBound{Fallback: LargestFirst{}}
+)
+
+type (
+	// CoinSelector picks the outputs a transaction of amount spends from the
+	// spendable outputs of an address.
+	CoinSelector interface {
+		Select(utxos []UTXO, amount int) ([]UTXO, error)
+	}
+
+	// Outpoint names an output by transaction and position.
+	Outpoint struct {
+		TxID  []byte
+		Index int
+	}
+
+	// LargestFirst spends the fewest outputs, leaving dust untouched.
+	LargestFirst struct{}
+
+	// SmallestFirst consolidates small outputs at the cost of larger
+	// transactions.
+	SmallestFirst struct{}
+
+	// BranchAndBound searches for inputs worth exactly the amount, so the
+	// transaction needs no change output. Fallback is used when there is no
+	// exact match; without one the selection fails with ErrNoExactMatch.
+	BranchAndBound struct {
+		Fallback CoinSelector
+	}
+
+	// Random spends outputs in random order, so the inputs of a transaction
+	// say less about the wallet.
+	Random struct{}
+
+	// Manual spends exactly the given outputs, for coin control.
+	Manual struct {
+		Inputs []Outpoint
+	}
+)
+
+// NewCoinSelector returns the strategy called name, or Manual when inputs are
+// given. An empty name gives DefaultCoinSelector.
+func NewCoinSelector(name string, inputs []Outpoint) (CoinSelector, error) {
+	if len(inputs) > 0 {
+		if name != "" {
+			return nil, errors.New("a coin selection strategy cannot be combined with manual inputs")
+		}
+		return Manual{Inputs: inputs}, nil
+	}
+
+	switch name {
+	case "":
+		return DefaultCoinSelector, nil
+	case "largest":
+		return LargestFirst{}, nil
+	case "smallest":
+		return SmallestFirst{}, nil
+	case "bnb":
+		return BranchAndBound{}, nil
+	case "random":
+		return Random{}, nil
+	}
+	return nil, fmt.Errorf("unknown coin selection strategy %q, use largest, smallest, bnb or random", name)
+}
+
+// ParseOutpoint reads an outpoint written as TXID:VOUT.
+func ParseOutpoint(s string) (Outpoint, error) {
+	txid, vout, ok := strings.Cut(s, ":")
+	if !ok {
+		return Outpoint{}, fmt.Errorf("input %q must be TXID:VOUT", s)
+	}
+	id, err := hex.DecodeString(txid)
+	if err != nil || len(id) == 0 {
+		return Outpoint{}, fmt.Errorf("input %q has an invalid transaction id", s)
+	}
+	index, err := strconv.Atoi(vout)
+	if err != nil || index < 0 {
+		return Outpoint{}, fmt.Errorf("input %q has an invalid output index", s)
+	}
+	return Outpoint{id, index}, nil
+}
+
+func (o Outpoint) String() string {
+	return fmt.Sprintf("%x:%d", o.TxID, o.Index)
+}
+
+func (u UTXO) Outpoint() Outpoint {
+	return Outpoint{u.TxID, u.Index}
+}
+
+// accumulate takes utxos in order until amount is covered.
+func accumulate(utxos []UTXO, amount int) ([]UTXO, error) {
+	var selected []UTXO
+	total := 0
+	for _, utxo := range utxos {
+		if total >= amount {
+			break
+		}
+		selected = append(selected, utxo)
+		total += utxo.Output.Value
+	}
+	if total < amount {
+		return nil, ErrInsufficientFunds
+	}
+	return selected, nil
+}
+
+func sortedByValue(utxos []UTXO, descending bool) []UTXO {
+	sorted := append([]UTXO{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if descending {
+			return sorted[i].Output.Value > sorted[j].Output.Value
+		}
+		return sorted[i].Output.Value < sorted[j].Output.Value
+	})
+	return sorted
+}
+
+func (LargestFirst) Select(utxos []UTXO, amount int) ([]UTXO, error) {
+	return accumulate(sortedByValue(utxos, true), amount)
+}
+
+func (SmallestFirst) Select(utxos []UTXO, amount int) ([]UTXO, error) {
+	return accumulate(sortedByValue(utxos, false), amount)
+}
+
+func (Random) Select(utxos []UTXO, amount int) ([]UTXO, error) {
+	shuffled := append([]UTXO{}, utxos...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return accumulate(shuffled, amount)
+}
+
+// Select walks the include/exclude tree of the outputs, largest first, and
+// prunes branches that overshoot the amount or can no longer reach it.
+func (b BranchAndBound) Select(utxos []UTXO, amount int) ([]UTXO, error) {
+	sorted := sortedByValue(utxos, true)
+
+	// remaining[i] is the value of sorted[i:].
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Output.Value
+	}
+
+	if remaining[0] < amount {
+		return nil, ErrInsufficientFunds
+	}
+
+	var picked []int
+	tries := 0
+	var search func(i, total int) bool
+	search = func(i, total int) bool {
+		tries++
+		switch {
+		case total == amount:
+			return true
+		case total > amount, i == len(sorted), total+remaining[i] < amount, tries > bnbMaxTries:
+			return false
+		}
+
+		picked = append(picked, i)
+		if search(i+1, total+sorted[i].Output.Value) {
+			return true
+		}
+		picked = picked[:len(picked)-1]
+
+		// Skipping an output only to include an equal one next gives the
+		// same sums again.
+		next := i + 1
+		for next < len(sorted) && sorted[next].Output.Value == sorted[i].Output.Value {
+			next++
+		}
+		return search(next, total)
+	}
+
+	if search(0, 0) {
+		selected := make([]UTXO, 0, len(picked))
+		for _, i := range picked {
+			selected = append(selected, sorted[i])
+		}
+		return selected, nil
+	}
+
+	if b.Fallback != nil {
+		return b.Fallback.Select(utxos, amount)
+	}
+	return nil, ErrNoExactMatch
+}
+
+func (m Manual) Select(utxos []UTXO, amount int) ([]UTXO, error) {
+	var selected []UTXO
+	total := 0
+	for i, in := range m.Inputs {
+		for _, prev := range m.Inputs[:i] {
+			if bytes.Equal(prev.TxID, in.TxID) && prev.Index == in.Index {
+				return nil, fmt.Errorf("%w: %s", ErrDuplicateInput, in)
+			}
+		}
+
+		found := false
+		for _, utxo := range utxos {
+			if bytes.Equal(utxo.TxID, in.TxID) && utxo.Index == in.Index {
+				selected = append(selected, utxo)
+				total += utxo.Output.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownInput, in)
+		}
+	}
+
+	if total < amount {
+		return nil, ErrInsufficientFunds
+	}
+	return selected, nil
+}
@@ -0,0 +1,110 @@
+package blockchain_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/FG420/go-block/blockchain"
+)
+
+func utxos(values ...int) []blockchain.UTXO {
+	var out []blockchain.UTXO
+	for i, v := range values {
+		out = append(out, blockchain.UTXO{TxID: []byte{byte(i + 1)}, Index: 0, Output: blockchain.TxOutput{Value: v}})
+	}
+	return out
+}
+
+func total(selected []blockchain.UTXO) int {
+	sum := 0
+	for _, u := range selected {
+		sum += u.Output.Value
+	}
+	return sum
+}
+
+func TestCoinSelectors(t *testing.T) {
+	available := utxos(5, 40, 1, 17, 23)
+
+	largest, err := blockchain.LargestFirst{}.Select(available, 50)
+	if err != nil || len(largest) != 2 || largest[0].Output.Value != 40 {
+		t.Errorf("largest first picked %v, %v", largest, err)
+	}
+
+	smallest, err := blockchain.SmallestFirst{}.Select(available, 20)
+	if err != nil || len(smallest) != 3 || total(smallest) != 23 {
+		t.Errorf("smallest first picked %v, %v", smallest, err)
+	}
+
+	exact, err := blockchain.BranchAndBound{}.Select(available, 46)
+	if err != nil || total(exact) != 46 {
+		t.Errorf("branch and bound picked %v, %v", exact, err)
+	}
+	if _, err := (blockchain.BranchAndBound{}).Select(available, 3); err != blockchain.ErrNoExactMatch {
+		t.Errorf("impossible exact match returned %v", err)
+	}
+	fallback, err := blockchain.DefaultCoinSelector.Select(available, 3)
+	if err != nil || total(fallback) != 40 {
+		t.Errorf("default selector fell back to %v, %v", fallback, err)
+	}
+
+	random, err := blockchain.Random{}.Select(available, 80)
+	if err != nil || total(random) < 80 {
+		t.Errorf("random picked %v, %v", random, err)
+	}
+
+	for _, s := range []blockchain.CoinSelector{blockchain.LargestFirst{}, blockchain.Random{}, blockchain.DefaultCoinSelector} {
+		if _, err := s.Select(available, 1000); err != blockchain.ErrInsufficientFunds {
+			t.Errorf("%T with too little funds returned %v", s, err)
+		}
+	}
+}
+
+func TestManualInputs(t *testing.T) {
+	available := utxos(5, 40, 1)
+
+	in, err := blockchain.ParseOutpoint(available[2].Outpoint().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manual, err := blockchain.NewCoinSelector("", []blockchain.Outpoint{in, available[0].Outpoint()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected, err := manual.Select(available, 6)
+	if err != nil || total(selected) != 6 {
+		t.Fatalf("manual selection picked %v, %v", selected, err)
+	}
+
+	if _, err := manual.Select(available, 7); err != blockchain.ErrInsufficientFunds {
+		t.Errorf("manual selection short of the amount returned %v", err)
+	}
+	unknown := blockchain.Manual{Inputs: []blockchain.Outpoint{{TxID: []byte{9}, Index: 0}}}
+	if _, err := unknown.Select(available, 1); !errors.Is(err, blockchain.ErrUnknownInput) {
+		t.Errorf("unknown input returned %v", err)
+	}
+	twice := blockchain.Manual{Inputs: []blockchain.Outpoint{in, in}}
+	if _, err := twice.Select(available, 1); !errors.Is(err, blockchain.ErrDuplicateInput) {
+		t.Errorf("duplicate input returned %v", err)
+	}
+	if _, err := blockchain.NewCoinSelector("largest", []blockchain.Outpoint{in}); err == nil {
+		t.Error("a strategy was combined with manual inputs")
+	}
+}
+
+func TestSkipLocked(t *testing.T) {
+	available := utxos(50, 30)
+	locked := map[string]bool{available[0].Outpoint().String(): true}
+
+	selected, err := blockchain.SkipLocked(blockchain.LargestFirst{}, locked).Select(available, 20)
+	if err != nil || len(selected) != 1 || selected[0].Output.Value != 30 {
+		t.Fatalf("picked %v, %v", selected, err)
+	}
+	if _, err := blockchain.SkipLocked(nil, locked).Select(available, 40); err != blockchain.ErrInsufficientFunds {
+		t.Errorf("locked funds were spendable: %v", err)
+	}
+	manual := blockchain.Manual{Inputs: []blockchain.Outpoint{available[0].Outpoint()}}
+	if _, err := blockchain.SkipLocked(manual, locked).Select(available, 1); !errors.Is(err, blockchain.ErrLockedInput) {
+		t.Errorf("locked manual input returned %v", err)
+	}
+}
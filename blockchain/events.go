@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"encoding/hex"
+
+	"github.com/FG420/go-block/events"
+	"github.com/FG420/go-block/wallet"
+)
+
+// Addresses lists the addresses a transaction spends from and pays to.
+func (tx *Transaction) Addresses() []string {
+	var addrs []string
+	seen := make(map[string]bool)
+
+	add := func(pubKeyHash []byte) {
+		addr := string(wallet.HashToAddress(pubKeyHash))
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Inputs {
+			add(wallet.PublicKeyHash(in.PubKey))
+		}
+	}
+	for _, out := range tx.Outputs {
+		add(out.PubKeyHash)
+	}
+
+	return addrs
+}
+
+func blockAddresses(b *Block) []string {
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, tx := range b.Transactions {
+		for _, addr := range tx.Addresses() {
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+func publishConnected(b *Block) {
+	hash := hex.EncodeToString(b.Hash)
+	events.Publish(events.Event{
+		Type:      events.BlockConnected,
+		Hash:      hash,
+		Height:    b.Height,
+		Addresses: blockAddresses(b),
+	})
+
+	for _, tx := range b.Transactions {
+		events.Publish(events.Event{
+			Type:      events.TxConfirmed,
+			Hash:      hash,
+			TxID:      hex.EncodeToString(tx.ID),
+			Height:    b.Height,
+			Addresses: tx.Addresses(),
+		})
+	}
+}
+
+func publishDisconnected(b *Block) {
+	events.Publish(events.Event{
+		Type:      events.BlockDisconnected,
+		Hash:      hex.EncodeToString(b.Hash),
+		Height:    b.Height,
+		Addresses: blockAddresses(b),
+	})
+}
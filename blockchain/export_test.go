@@ -0,0 +1,26 @@
+package blockchain
+
+import (
+	"encoding/hex"
+
+	"github.com/FG420/go-block/handlers"
+	"github.com/dgraph-io/badger"
+)
+
+// Downgrade rewrites the UTXO set the way versions before heights left it.
+func (u *UTXOSet) Downgrade() {
+	err := u.BlockChain.Database.Update(func(txn *badger.Txn) error {
+		for txID, outs := range u.BlockChain.FindUTxO() {
+			id, err := hex.DecodeString(txID)
+			if err != nil {
+				return err
+			}
+			outs.Height = 0
+			if err := txn.Set(append(utxoPrefix, id...), outs.Serialize()); err != nil {
+				return err
+			}
+		}
+		return txn.Delete(utxoVersionKey)
+	})
+	handlers.HandleErr(err)
+}
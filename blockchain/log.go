@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/FG420/go-block/logging"
+)
+
+var (
+	chainLog = logging.Get(logging.Chain)
+	minerLog = logging.Get(logging.Miner)
+)
+
+// dbLogger routes badger's logging into the chain subsystem. Badger is chatty
+// at info level, so its info messages are logged as debug.
+type dbLogger struct {
+	*slog.Logger
+}
+
+func dbMessage(format string, args ...interface{}) string {
+	return strings.TrimSpace(fmt.Sprintf(format, args...))
+}
+
+func (l dbLogger) Errorf(format string, args ...interface{}) {
+	l.Error(dbMessage(format, args...))
+}
+
+func (l dbLogger) Warningf(format string, args ...interface{}) {
+	l.Warn(dbMessage(format, args...))
+}
+
+func (l dbLogger) Infof(format string, args ...interface{}) {
+	l.Debug(dbMessage(format, args...))
+}
+
+func (l dbLogger) Debugf(format string, args ...interface{}) {
+	l.Debug(dbMessage(format, args...))
+}
@@ -0,0 +1,47 @@
+package blockchain
+
+import (
+	"github.com/FG420/go-block/metrics"
+)
+
+var (
+	hashesTotal  = metrics.NewCounter("pow_hashes_total", "Proof of work hashes computed while mining.")
+	hashrate     = metrics.NewGauge("pow_hashrate", "Hashes per second of the last mined block.")
+	reorgsTotal  = metrics.NewCounter("reorgs_total", "Chain reorganizations.")
+	reorgBlocks  = metrics.NewCounter("reorg_disconnected_blocks_total", "Blocks disconnected by reorganizations.")
+	lastReorg    = metrics.NewGauge("reorg_depth_last", "Blocks disconnected by the last reorganization.")
+	deepestReorg = metrics.NewGauge("reorg_depth_max", "Blocks disconnected by the deepest reorganization.")
+)
+
+func recordReorg(depth int) {
+	if depth == 0 {
+		return
+	}
+
+	reorgsTotal.Inc()
+	reorgBlocks.Add(float64(depth))
+	lastReorg.Set(float64(depth))
+	if float64(depth) > deepestReorg.Value() {
+		deepestReorg.Set(float64(depth))
+	}
+}
+
+// RegisterMetrics exposes gauges that are read from the chain's database when
+// metrics are scraped.
+func (bc *BlockChain) RegisterMetrics() {
+	metrics.NewGaugeFunc("chain_height", "Height of the best block.", func() float64 {
+		return float64(bc.GetBestHeight())
+	})
+	metrics.NewGaugeFunc("utxo_set_transactions", "Transactions with unspent outputs in the UTXO set.", func() float64 {
+		utxoSet := UTXOSet{BlockChain: bc}
+		return float64(utxoSet.CountTransactions())
+	})
+	metrics.NewGaugeFunc("db_lsm_size_bytes", "Size of the database LSM tree.", func() float64 {
+		lsm, _ := bc.Database.Size()
+		return float64(lsm)
+	})
+	metrics.NewGaugeFunc("db_vlog_size_bytes", "Size of the database value log.", func() float64 {
+		_, vlog := bc.Database.Size()
+		return float64(vlog)
+	})
+}
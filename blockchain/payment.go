@@ -0,0 +1,42 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/FG420/go-block/wallet"
+)
+
+var ErrInvalidPayment = errors.New("invalid payment")
+
+// Payment is one recipient of a transaction.
+type Payment struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// ValidatePayments checks every address and amount of a batch and returns
+// its total. An address may only be paid once per transaction.
+func ValidatePayments(payments []Payment) (int, error) {
+	if len(payments) == 0 {
+		return 0, fmt.Errorf("%w: no recipients", ErrInvalidPayment)
+	}
+
+	total := 0
+	seen := make(map[string]bool, len(payments))
+	for i, p := range payments {
+		if !wallet.ValidateAddress(p.Address) {
+			return 0, fmt.Errorf("%w: recipient %d: address %q is not valid", ErrInvalidPayment, i+1, p.Address)
+		}
+		if p.Amount <= 0 {
+			return 0, fmt.Errorf("%w: recipient %d: amount must be positive", ErrInvalidPayment, i+1)
+		}
+		if seen[p.Address] {
+			return 0, fmt.Errorf("%w: address %s is paid twice", ErrInvalidPayment, p.Address)
+		}
+		seen[p.Address] = true
+		total += p.Amount
+	}
+
+	return total, nil
+}
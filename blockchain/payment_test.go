@@ -0,0 +1,45 @@
+package blockchain_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/wallet"
+)
+
+// newWallets returns an in-memory wallet with n addresses from a fresh seed.
+func newWallets(t *testing.T, n int) (*wallet.Wallets, []string) {
+	t.Helper()
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet)}
+	var addrs []string
+	for i := 0; i < n; i++ {
+		addr, err := ws.AddWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return ws, addrs
+}
+
+func TestValidatePayments(t *testing.T) {
+	_, addrs := newWallets(t, 2)
+	a, b := addrs[0], addrs[1]
+
+	total, err := blockchain.ValidatePayments([]blockchain.Payment{{a, 3}, {b, 4}})
+	if err != nil || total != 7 {
+		t.Fatalf("got %d, %v", total, err)
+	}
+
+	for name, payments := range map[string][]blockchain.Payment{
+		"empty":     nil,
+		"address":   {{a, 3}, {"1bogus", 4}},
+		"amount":    {{a, 0}},
+		"duplicate": {{a, 3}, {a, 4}},
+	} {
+		if _, err := blockchain.ValidatePayments(payments); !errors.Is(err, blockchain.ErrInvalidPayment) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
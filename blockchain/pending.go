@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/FG420/go-block/handlers"
+	"github.com/FG420/go-block/wallet"
+	"github.com/dgraph-io/badger"
+)
+
+var ErrLockedInput = errors.New("input is spent by an unconfirmed wallet transaction")
+
+// skipLocked keeps the inputs of the wallet's pending transactions away from
+// a selector.
+type skipLocked struct {
+	selector CoinSelector
+	locked   map[string]bool
+}
+
+// SkipLocked wraps selector so it never sees the outputs in locked, as
+// returned by Wallets.LockedInputs. Manual inputs that are locked fail with
+// ErrLockedInput.
+func SkipLocked(selector CoinSelector, locked map[string]bool) CoinSelector {
+	if selector == nil {
+		selector = DefaultCoinSelector
+	}
+	return skipLocked{selector, locked}
+}
+
+func (s skipLocked) Select(utxos []UTXO, amount int) ([]UTXO, error) {
+	if manual, ok := s.selector.(Manual); ok {
+		for _, in := range manual.Inputs {
+			if s.locked[in.String()] {
+				return nil, fmt.Errorf("%w: %s", ErrLockedInput, in)
+			}
+		}
+	}
+
+	var free []UTXO
+	for _, utxo := range utxos {
+		if !s.locked[utxo.Outpoint().String()] {
+			free = append(free, utxo)
+		}
+	}
+	return s.selector.Select(free, amount)
+}
+
+// NewPendingTx records tx for the wallet, sent while the best height was
+// height.
+func NewPendingTx(tx *Transaction, height int) *wallet.PendingTx {
+	pending := &wallet.PendingTx{Raw: tx.Serialize(), Height: height, Broadcast: height}
+	for _, in := range tx.Inputs {
+		pending.Inputs = append(pending.Inputs, Outpoint{in.ID, in.Out}.String())
+	}
+	return pending
+}
+
+// HasOutput reports whether output index of txID is unspent.
+func (u UTXOSet) HasOutput(txID []byte, index int) bool {
+	found := false
+	err := u.BlockChain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(utxoPrefix, txID...))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			outs := DeserializeOuts(val)
+			for i := range outs.Outputs {
+				if outs.Index(i) == index {
+					found = true
+				}
+			}
+			return nil
+		})
+	})
+	handlers.HandleErr(err)
+	return found
+}
+
+// ResolvePending stops tracking the wallet's pending transactions that made
+// it into a block, and those whose inputs were spent by another transaction.
+// It returns the IDs of both.
+func (u UTXOSet) ResolvePending(ws *wallet.Wallets) (confirmed, dropped []string) {
+	if len(ws.Pending) == 0 {
+		return nil, nil
+	}
+
+	// A transaction can only be in blocks mined after it was sent.
+	minHeight := -1
+	for _, p := range ws.Pending {
+		if minHeight < 0 || p.Height < minHeight {
+			minHeight = p.Height
+		}
+	}
+	iter := u.BlockChain.Iterator()
+	for len(iter.CurrentHash) > 0 {
+		block := iter.Next()
+		if block.Height < minHeight {
+			break
+		}
+		for _, tx := range block.Transactions {
+			id := hex.EncodeToString(tx.ID)
+			if _, ok := ws.Pending[id]; ok {
+				confirmed = append(confirmed, id)
+				ws.RemovePending(id)
+			}
+		}
+	}
+
+	for id, p := range ws.Pending {
+		for _, in := range p.Inputs {
+			outpoint, err := ParseOutpoint(in)
+			if err != nil || !u.HasOutput(outpoint.TxID, outpoint.Index) {
+				dropped = append(dropped, id)
+				ws.RemovePending(id)
+				break
+			}
+		}
+	}
+
+	return confirmed, dropped
+}
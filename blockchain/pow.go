@@ -2,15 +2,24 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"log"
 	"math"
 	"math/big"
+	"time"
 )
 
-const Difficulty = 16
+const (
+	Difficulty = 16
+
+	// The miner reports its progress at most every progressInterval, checked
+	// once every progressCheck hashes.
+	progressInterval = 10 * time.Second
+	progressCheck    = 1 << 14
+)
 
 type ProofOfWork struct {
 	Block  *Block
@@ -18,15 +27,22 @@ type ProofOfWork struct {
 }
 
 func (pow *ProofOfWork) Run() (int, []byte) {
+	nonce, hash, _ := pow.RunContext(context.Background())
+	return nonce, hash
+}
+
+// RunContext searches for a nonce until one is found or ctx is done.
+func (pow *ProofOfWork) RunContext(ctx context.Context) (int, []byte, error) {
 	var intHash big.Int
 	var hash [32]byte
 	nonce := 0
+	start := time.Now()
+	lastReport := start
 
 	for nonce < math.MaxInt64 {
 		data := pow.InitData(nonce)
 		hash = sha256.Sum256(data)
 
-		fmt.Printf("\r%x", hash)
 		intHash.SetBytes(hash[:])
 
 		if intHash.Cmp(pow.Target) == -1 {
@@ -35,10 +51,31 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 			nonce++
 		}
 
+		if nonce%progressCheck != 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			minerLog.Info("mining cancelled", "height", pow.Block.Height, "nonce", nonce)
+			return 0, nil, err
+		}
+		if time.Since(lastReport) >= progressInterval {
+			lastReport = time.Now()
+			rate := float64(nonce) / lastReport.Sub(start).Seconds()
+			hashrate.Set(rate)
+			minerLog.Info("mining", "height", pow.Block.Height, "nonce", nonce, "hashrate", int(rate))
+		}
+	}
+
+	hashes := float64(nonce + 1)
+	hashesTotal.Add(hashes)
+	elapsed := time.Since(start)
+	if elapsed > 0 {
+		hashrate.Set(hashes / elapsed.Seconds())
 	}
-	fmt.Println()
+	minerLog.Debug("proof of work found", "height", pow.Block.Height, "nonce", nonce,
+		"hash", fmt.Sprintf("%x", hash), "elapsed", elapsed.Round(time.Millisecond))
 
-	return nonce, hash[:]
+	return nonce, hash[:], nil
 }
 
 func (pow *ProofOfWork) Validate() bool {
@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/FG420/go-block/wallet"
+)
+
+var ErrRawTx = errors.New("invalid raw transaction")
+
+// RawTx carries a transaction between createrawtx, signrawtx and sendrawtx.
+// The previous transactions travel with it, so a signer without the chain
+// can both sign and check what it is spending.
+type RawTx struct {
+	Hex     string   `json:"hex"`
+	PrevTxs []string `json:"prevtxs"`
+}
+
+// NewRawTx packs tx with the transactions its inputs spend from.
+func NewRawTx(tx *Transaction, prevTxs map[string]Transaction) *RawTx {
+	raw := &RawTx{Hex: hex.EncodeToString(tx.Serialize())}
+	for _, prev := range prevTxs {
+		raw.PrevTxs = append(raw.PrevTxs, hex.EncodeToString(prev.Serialize()))
+	}
+	return raw
+}
+
+// ReadRawTx loads a raw transaction file.
+func ReadRawTx(path string) (*RawTx, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw RawTx
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRawTx, err)
+	}
+	return &raw, nil
+}
+
+// WriteFile saves r to path, or prints it when path is empty.
+func (r *RawTx) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Decode unpacks the transaction and its previous transactions. Every
+// previous transaction must hash to the ID its input refers to and have the
+// spent output, so their values can be trusted.
+func (r *RawTx) Decode() (*Transaction, map[string]Transaction, error) {
+	tx, err := decodeTx(r.Hex)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prevTxs := make(map[string]Transaction)
+	for _, s := range r.PrevTxs {
+		prev, err := decodeTx(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !bytes.Equal(prev.txID(), prev.ID) {
+			return nil, nil, fmt.Errorf("%w: previous transaction %x does not match its ID", ErrRawTx, prev.ID)
+		}
+		prevTxs[hex.EncodeToString(prev.ID)] = *prev
+	}
+
+	if tx.IsCoinbase() || len(tx.Inputs) == 0 {
+		return nil, nil, fmt.Errorf("%w: no inputs", ErrRawTx)
+	}
+	for _, in := range tx.Inputs {
+		prev, ok := prevTxs[hex.EncodeToString(in.ID)]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: previous transaction %x is missing", ErrRawTx, in.ID)
+		}
+		if in.Out < 0 || in.Out >= len(prev.Outputs) {
+			return nil, nil, fmt.Errorf("%w: %x has no output %d", ErrRawTx, in.ID, in.Out)
+		}
+	}
+
+	return tx, prevTxs, nil
+}
+
+func decodeTx(s string) (*Transaction, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRawTx, err)
+	}
+	return DecodeTransaction(data)
+}
+
+// DecodeTransaction is DeserializeTransaction for untrusted input, returning
+// an error instead of panicking.
+func DecodeTransaction(data []byte) (tx *Transaction, err error) {
+	// DeserializeTransaction panics on bad input.
+	defer func() {
+		if r := recover(); r != nil {
+			tx, err = nil, fmt.Errorf("%w: %v", ErrRawTx, r)
+		}
+	}()
+	decoded := DeserializeTransaction(data)
+	return &decoded, nil
+}
+
+// txID recomputes the ID of tx, which is taken before the inputs are signed.
+func (tx *Transaction) txID() []byte {
+	txCopy := Transaction{Outputs: tx.Outputs}
+	for _, in := range tx.Inputs {
+		txCopy.Inputs = append(txCopy.Inputs, TxInput{in.ID, in.Out, nil, in.PubKey})
+	}
+	return txCopy.Hash()
+}
+
+// Signed reports whether every input of tx carries a signature.
+func (tx *Transaction) Signed() bool {
+	for _, in := range tx.Inputs {
+		if len(in.Signature) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// InputValue sums the outputs tx spends.
+func InputValue(tx *Transaction, prevTxs map[string]Transaction) int {
+	total := 0
+	for _, in := range tx.Inputs {
+		total += prevTxs[hex.EncodeToString(in.ID)].Outputs[in.Out].Value
+	}
+	return total
+}
+
+// SignRawTx signs the inputs of r with the key in ws they are locked to.
+// It needs no chain, only the previous transactions carried by r.
+func SignRawTx(r *RawTx, ws *wallet.Wallets) (*RawTx, error) {
+	tx, prevTxs, err := r.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	var signer *wallet.Wallet
+	for _, in := range tx.Inputs {
+		pubKeyHash := prevTxs[hex.EncodeToString(in.ID)].Outputs[in.Out].PubKeyHash
+		addr := string(wallet.HashToAddress(pubKeyHash))
+		w := ws.GetAddress(addr)
+		if w == nil {
+			return nil, fmt.Errorf("address %s is not in the wallet", addr)
+		}
+		if signer != nil && signer != w {
+			return nil, errors.New("inputs are locked to more than one address")
+		}
+		if !bytes.Equal(in.PubKey, w.PublicKey) {
+			return nil, fmt.Errorf("%w: input public key does not match address %s", ErrRawTx, addr)
+		}
+		signer = w
+	}
+	if signer.WatchOnly() {
+		return nil, wallet.ErrWatchOnly
+	}
+	if signer.Locked() {
+		return nil, wallet.ErrLocked
+	}
+
+	tx.Sign(*signer.PrivateKey, prevTxs)
+	if !tx.Verify(prevTxs) {
+		return nil, errors.New("signature does not verify")
+	}
+	return &RawTx{Hex: hex.EncodeToString(tx.Serialize()), PrevTxs: r.PrevTxs}, nil
+}
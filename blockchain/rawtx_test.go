@@ -0,0 +1,63 @@
+package blockchain_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/wallet"
+)
+
+func TestSignRawTx(t *testing.T) {
+	ws, addrs := newWallets(t, 2)
+	from, to := addrs[0], addrs[1]
+
+	// The online side only knows the public key.
+	watch, err := wallet.NewWatchOnlyPubKey(ws.GetAddress(from).PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := blockchain.CoinbaseTx(from, "")
+	tx := blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{{ID: prev.ID, Out: 0, PubKey: watch.PublicKey}},
+		Outputs: []blockchain.TxOutput{*blockchain.NewTxOutput(60, to), *blockchain.NewTxOutput(40, from)},
+	}
+	tx.ID = tx.Hash()
+	prevTxs := map[string]blockchain.Transaction{hex.EncodeToString(prev.ID): *prev}
+	raw := blockchain.NewRawTx(&tx, prevTxs)
+
+	signed, err := blockchain.SignRawTx(raw, ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, decodedPrev, err := signed.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Signed() || !decoded.Verify(decodedPrev) || hex.EncodeToString(decoded.ID) != hex.EncodeToString(tx.ID) {
+		t.Fatal("signed transaction does not verify")
+	}
+	if value := blockchain.InputValue(decoded, decodedPrev); value != 100 {
+		t.Fatalf("input value %d", value)
+	}
+
+	// A previous transaction that lies about its outputs is rejected.
+	forged := *prev
+	forged.Outputs = []blockchain.TxOutput{*blockchain.NewTxOutput(1000, from)}
+	tampered := &blockchain.RawTx{Hex: raw.Hex, PrevTxs: []string{hex.EncodeToString(forged.Serialize())}}
+	if _, err := blockchain.SignRawTx(tampered, ws); !errors.Is(err, blockchain.ErrRawTx) {
+		t.Fatalf("got %v", err)
+	}
+
+	// So is one the inputs do not spend from.
+	if _, _, err := (&blockchain.RawTx{Hex: raw.Hex}).Decode(); !errors.Is(err, blockchain.ErrRawTx) {
+		t.Fatalf("got %v", err)
+	}
+
+	other, _ := newWallets(t, 1)
+	if _, err := blockchain.SignRawTx(raw, other); err == nil {
+		t.Fatal("signed with a wallet that does not own the inputs")
+	}
+}
@@ -104,7 +104,6 @@ func (tx *Transaction) TrimmedCopy() *Transaction {
 
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTxs map[string]Transaction) {
 	if tx.IsCoinbase() {
-		log.Println("Coinbase transaction, no signing needed.")
 		return
 	}
 
@@ -125,7 +124,10 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTxs map[string]Transac
 
 		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
 		handlers.HandleErr(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+		// Pad r and s so Verify can split the signature in half.
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
 
 		tx.Inputs[inId].Signature = signature
 		txCopy.Inputs[inId].PubKey = nil
@@ -188,42 +190,91 @@ func CoinbaseTx(to, data string) *Transaction {
 	txout := NewTxOutput(100, to)
 
 	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
-	tx.Hash()
+	tx.ID = tx.Hash()
 
 	return &tx
 }
 
-func NewTransaction(w *wallet.Wallet, to string, amount int, utxo *UTXOSet) *Transaction {
+// NewTransaction pays amount from w to to, spending the outputs picked by
+// selector, or by DefaultCoinSelector when it is nil. Change goes to change,
+// or back to the address of w when change is empty.
+func NewTransaction(w *wallet.Wallet, to, change string, amount int, utxo *UTXOSet, selector CoinSelector) (*Transaction, error) {
+	return NewPaymentTransaction(w, []Payment{{to, amount}}, change, utxo, selector)
+}
+
+// NewPaymentTransaction pays every payment from w in one transaction, with
+// the outputs in the order given and a single change output last.
+func NewPaymentTransaction(w *wallet.Wallet, payments []Payment, change string, utxo *UTXOSet, selector CoinSelector) (*Transaction, error) {
+	if w.WatchOnly() {
+		return nil, wallet.ErrWatchOnly
+	}
+	if w.Locked() {
+		return nil, wallet.ErrLocked
+	}
+
+	tx, prevTxs, err := NewUnsignedTransaction(w, payments, change, utxo, selector)
+	if err != nil {
+		return nil, err
+	}
+	tx.Sign(*w.PrivateKey, prevTxs)
+	chainLog.Debug("transaction signed", "txid", hex.EncodeToString(tx.ID), "inputs", len(tx.Inputs))
+
+	return tx, nil
+}
+
+// NewUnsignedTransaction builds the transaction of NewPaymentTransaction
+// without signing it, and returns the transactions its inputs spend from for
+// the signer. w needs a public key but no private key.
+func NewUnsignedTransaction(w *wallet.Wallet, payments []Payment, change string, utxo *UTXOSet, selector CoinSelector) (*Transaction, map[string]Transaction, error) {
 	var inputs []TxInput
 	var outputs []TxOutput
 
-	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
-	acc, validOutputs := utxo.FindSpendableOutputs(pubKeyHash, amount)
-
-	if acc < amount {
-		log.Panic("Error: not enough funds")
+	if w.PublicKey == nil {
+		return nil, nil, fmt.Errorf("address %s has no public key, import it with -pubkey or -xpub", w.Address())
+	}
+	amount, err := ValidatePayments(payments)
+	if err != nil {
+		return nil, nil, err
+	}
+	if selector == nil {
+		selector = DefaultCoinSelector
 	}
 
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		handlers.HandleErr(err)
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	selected, err := selector.Select(utxo.FindUnspent(pubKeyHash), amount)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		for _, out := range outs {
-			newInput := TxInput{txID, out, nil, w.PublicKey}
-			inputs = append(inputs, newInput)
+	acc := 0
+	prevTxs := make(map[string]Transaction)
+	for _, out := range selected {
+		inputs = append(inputs, TxInput{out.TxID, out.Index, nil, w.PublicKey})
+		acc += out.Output.Value
+
+		id := hex.EncodeToString(out.TxID)
+		if _, ok := prevTxs[id]; !ok {
+			prevTx, err := utxo.BlockChain.FindTransaction(out.TxID)
+			if err != nil {
+				return nil, nil, err
+			}
+			prevTxs[id] = prevTx
 		}
 	}
 
-	from := fmt.Sprintf("%s", w.Address())
+	if change == "" {
+		change = fmt.Sprintf("%s", w.Address())
+	}
 
-	outputs = append(outputs, *NewTxOutput(amount, to))
+	for _, p := range payments {
+		outputs = append(outputs, *NewTxOutput(p.Amount, p.Address))
+	}
 	if acc > amount {
-		outputs = append(outputs, *NewTxOutput(acc-amount, from))
+		outputs = append(outputs, *NewTxOutput(acc-amount, change))
 	}
 
 	tx := Transaction{nil, inputs, outputs}
 	tx.ID = tx.Hash()
-	utxo.BlockChain.SignTransaction(&tx, *w.PrivateKey)
 
-	return &tx
+	return &tx, prevTxs, nil
 }
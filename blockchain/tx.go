@@ -21,8 +21,15 @@ type (
 		PubKeyHash []byte
 	}
 
+	// TxOutputs holds the unspent outputs of a transaction. Indexes records
+	// each output's position in the transaction, which changes once earlier
+	// outputs are spent. Height is that of the block confirming the
+	// transaction; sets written before it existed are rebuilt by
+	// UTXOSet.Upgrade when the chain is opened.
 	TxOutputs struct {
 		Outputs []TxOutput
+		Indexes []int
+		Height  int
 	}
 )
 
@@ -41,6 +48,15 @@ func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
 	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
 }
 
+// Index returns the position in its transaction of the i-th unspent output.
+// Entries written before Indexes existed fall back to i.
+func (outs *TxOutputs) Index(i int) int {
+	if i < len(outs.Indexes) {
+		return outs.Indexes[i]
+	}
+	return i
+}
+
 func (outs *TxOutputs) Serialize() []byte {
 	var buffer bytes.Buffer
 
@@ -9,14 +9,28 @@ import (
 	"github.com/dgraph-io/badger"
 )
 
+// utxoVersion is the layout of the UTXO set, stored under utxoVersionKey.
+// Version 1 records the confirmation height of every entry.
+const utxoVersion = 1
+
 var (
-	utxoPrefix   = []byte("utxo-")
-	prefixLength = len(utxoPrefix)
+	utxoPrefix     = []byte("utxo-")
+	prefixLength   = len(utxoPrefix)
+	utxoVersionKey = []byte("utxov")
 )
 
-type UTXOSet struct {
-	BlockChain *BlockChain
-}
+type (
+	UTXOSet struct {
+		BlockChain *BlockChain
+	}
+
+	UTXO struct {
+		TxID   []byte
+		Index  int
+		Output TxOutput
+		Height int
+	}
+)
 
 func (u *UTXOSet) Reindex() {
 	db := u.BlockChain.Database
@@ -37,11 +51,38 @@ func (u *UTXOSet) Reindex() {
 			handlers.HandleErr(err)
 		}
 
-		return nil
+		return txn.Set(utxoVersionKey, []byte{utxoVersion})
 	})
 	handlers.HandleErr(err)
 }
 
+// Upgrade rebuilds a UTXO set written by an older version, whose entries
+// read height 0 and would count every confirmation from genesis.
+func (u *UTXOSet) Upgrade() {
+	version := 0
+	err := u.BlockChain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(utxoVersionKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) > 0 {
+				version = int(val[0])
+			}
+			return nil
+		})
+	})
+	handlers.HandleErr(err)
+
+	if version < utxoVersion {
+		chainLog.Info("rebuilding UTXO set", "version", version, "new", utxoVersion)
+		u.Reindex()
+	}
+}
+
 func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 	deleteKeys := func(keysForDelete [][]byte) error {
 		if err := u.BlockChain.Database.Update(func(txn *badger.Txn) error {
@@ -100,10 +141,12 @@ func (u *UTXOSet) Update(block *Block) {
 					handlers.HandleErr(err)
 					err = item.Value(func(val []byte) error {
 						outs := DeserializeOuts(val)
+						updatedOuts.Height = outs.Height
 
-						for outIdx, out := range outs.Outputs {
-							if outIdx != in.Out {
+						for i, out := range outs.Outputs {
+							if outs.Index(i) != in.Out {
 								updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+								updatedOuts.Indexes = append(updatedOuts.Indexes, outs.Index(i))
 							}
 						}
 						return nil
@@ -123,9 +166,10 @@ func (u *UTXOSet) Update(block *Block) {
 				}
 			}
 
-			var newOutputs TxOutputs
-			for _, out := range tx.Outputs {
+			newOutputs := TxOutputs{Height: block.Height}
+			for outIdx, out := range tx.Outputs {
 				newOutputs.Outputs = append(newOutputs.Outputs, out)
+				newOutputs.Indexes = append(newOutputs.Indexes, outIdx)
 			}
 
 			txID := append(utxoPrefix, tx.ID...)
@@ -193,6 +237,48 @@ func (u *UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 	return UTXOs
 }
 
+// FindUnspent returns the unspent outputs locked to pubKeyHash together with
+// the outpoint that spends them.
+func (u *UTXOSet) FindUnspent(pubKeyHash []byte) []UTXO {
+	return u.FindUnspentFor(map[string]bool{hex.EncodeToString(pubKeyHash): true})
+}
+
+// FindUnspentFor returns the unspent outputs locked to any of the hex encoded
+// public key hashes in one pass over the set.
+func (u *UTXOSet) FindUnspentFor(pubKeyHashes map[string]bool) []UTXO {
+	var UTXOs []UTXO
+
+	db := u.BlockChain.Database
+
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			item := it.Item()
+			txID := bytes.TrimPrefix(item.KeyCopy(nil), utxoPrefix)
+			err := item.Value(func(val []byte) error {
+				outs := DeserializeOuts(val)
+
+				for i, out := range outs.Outputs {
+					if pubKeyHashes[hex.EncodeToString(out.PubKeyHash)] {
+						UTXOs = append(UTXOs, UTXO{txID, outs.Index(i), out, outs.Height})
+					}
+				}
+
+				return nil
+			})
+			handlers.HandleErr(err)
+		}
+		return nil
+	})
+	handlers.HandleErr(err)
+
+	return UTXOs
+}
+
 func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
 	unspentOuts := make(map[string][]int)
 	accumulated := 0
@@ -213,10 +299,10 @@ func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[
 			err := item.Value(func(val []byte) error {
 				outs := DeserializeOuts(val)
 
-				for outIdx, out := range outs.Outputs {
+				for i, out := range outs.Outputs {
 					if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
 						accumulated += out.Value
-						unspentOuts[txID] = append(unspentOuts[txID], outIdx)
+						unspentOuts[txID] = append(unspentOuts[txID], outs.Index(i))
 					}
 				}
 
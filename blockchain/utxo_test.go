@@ -0,0 +1,67 @@
+package blockchain_test
+
+import (
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/wallet"
+)
+
+// TestMain runs the tests in a scratch directory, as chains live under
+// ./tmp.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "blockchain-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func heights(chain *blockchain.BlockChain, addr string) map[int]int {
+	utxoSet := blockchain.UTXOSet{BlockChain: chain}
+	found := make(map[int]int)
+	for _, utxo := range utxoSet.FindUnspentFor(map[string]bool{hex.EncodeToString(wallet.AddressToHash(addr)): true}) {
+		found[utxo.Height]++
+	}
+	return found
+}
+
+func TestUTXOUpgrade(t *testing.T) {
+	addr := string(wallet.MakeWallet().Address())
+	chain, err := blockchain.InitBlockChain(addr, "utxo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.MineBlock([]*blockchain.Transaction{blockchain.CoinbaseTx(addr, "")})
+	utxoSet := blockchain.UTXOSet{BlockChain: chain}
+	utxoSet.Reindex()
+
+	want := map[int]int{0: 1, 1: 1}
+	if got := heights(chain, addr); len(got) != 2 || got[0] != 1 || got[1] != 1 {
+		t.Fatalf("heights %v, want %v", got, want)
+	}
+
+	// A set written before heights existed is rebuilt on open.
+	utxoSet.Downgrade()
+	if got := heights(chain, addr); got[0] != 2 {
+		t.Fatalf("downgraded heights %v", got)
+	}
+	chain.Close()
+
+	chain, err = blockchain.ContinueBlockChain("utxo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	if got := heights(chain, addr); len(got) != 2 || got[0] != 1 || got[1] != 1 {
+		t.Fatalf("heights %v, want %v", got, want)
+	}
+}
@@ -1,43 +1,259 @@
 package cli
 
 import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
-	"runtime"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/explorer"
 	"github.com/FG420/go-block/handlers"
+	"github.com/FG420/go-block/logging"
+	"github.com/FG420/go-block/metrics"
 	"github.com/FG420/go-block/network"
+	"github.com/FG420/go-block/rpc"
 	"github.com/FG420/go-block/wallet"
 )
 
-type CommandLine struct{}
+type (
+	CommandLine struct{}
+
+	// exitCode is panicked by exit and recovered in Run, so deferred
+	// cleanup such as closing the database still happens.
+	exitCode int
+)
+
+var nodeLog = logging.Get(logging.Net)
+
+func exit(code int) {
+	panic(exitCode(code))
+}
+
+func openChain(nodeId string) *blockchain.BlockChain {
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	return chain
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func readPassphrase(prompt string) string {
+	fmt.Print(prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println()
+		exit(1)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// loadWallets loads the wallet of nodeId and asks for the passphrase when it
+// is encrypted and needed to sign or add keys.
+func loadWallets(nodeId string, unlock bool) *wallet.Wallets {
+	wallets, err := wallet.CreateWallets(nodeId)
+	handlers.HandleErr(err)
+
+	if unlock && wallets.Locked() {
+		if err := wallets.Unlock(readPassphrase("Wallet passphrase: ")); err != nil {
+			fmt.Println(err)
+			exit(1)
+		}
+	}
+	return wallets
+}
+
+// askPassphrase reads the passphrase of the wallet of nodeId if it is
+// encrypted, so it is known before updateWallets locks the wallet file.
+func askPassphrase(nodeId string) string {
+	if !loadWallets(nodeId, false).Locked() {
+		return ""
+	}
+	return readPassphrase("Wallet passphrase: ")
+}
+
+// updateWallets applies fn to the wallet of nodeId while holding the wallet
+// file lock, so a running node's own writes are not lost, and exits when it
+// fails. The wallet is unlocked with passphrase first when one is given.
+func updateWallets(nodeId, passphrase string, fn func(ws *wallet.Wallets) error) {
+	err := wallet.Update(nodeId, func(ws *wallet.Wallets) error {
+		if passphrase != "" && ws.Locked() {
+			if err := ws.Unlock(passphrase); err != nil {
+				return err
+			}
+		}
+		return fn(ws)
+	})
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+}
+
+func validateAddress(addr string) {
+	if !wallet.ValidateAddress(addr) {
+		fmt.Printf("Address %s is not valid\n", addr)
+		exit(1)
+	}
+}
+
+type addrList []string
+
+func (l *addrList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *addrList) Set(value string) error {
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			*l = append(*l, addr)
+		}
+	}
+	return nil
+}
+
+// relayFlags adds the flags choosing the peers a transaction is relayed to
+// when no node is running.
+func relayFlags(cmd *flag.FlagSet) *network.Config {
+	relay := &network.Config{}
+	cmd.BoolVar(&relay.Encrypt, "encrypt", false, "Relay over the encrypted transport")
+	cmd.Var((*addrList)(&relay.Connect), "connect", "Relay only to the given peers")
+	cmd.Var((*addrList)(&relay.AddNodes), "addnode", "Also relay to the given peer")
+	cmd.StringVar(&relay.SeedsFile, "seeds", "", "File with seed addresses to relay to")
+	return relay
+}
 
 func (cli *CommandLine) printUsage() {
 	fmt.Println("Usage: ")
+	fmt.Println(" getbalance, send and printchain talk to the running node over RPC when one is found")
+	fmt.Println(" (RPC_ADDR, RPC_USER and RPC_PASSWORD env override the detected cookie)")
 	fmt.Println(" getbalance -addr ADDRESS - get the balance of the address")
 	fmt.Println(" createbc -addr ADDRESS - Creates a blockchain")
 	fmt.Println(" printchain - Prints the blocks in the chain")
 	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins. Then -mine flag enables the mining of that transaction")
+	fmt.Println("      -encrypt - Relay the transaction over the encrypted transport")
+	fmt.Println("      -connect PEER, -addnode PEER, -seeds FILE - Peers to relay to without a running node (default the address book and seeds)")
+	fmt.Println("      -coinselect largest|smallest|bnb|random - How inputs are chosen (default exact match, else largest first)")
+	fmt.Println("      -input TXID:VOUT - Spend exactly the given outputs (repeatable)")
+	fmt.Println(" sendmany -from FROM -to ADDRESS:AMOUNT (repeatable) -file FILE - Pay several addresses in one transaction")
+	fmt.Println("      FILE is CSV (address,amount per line) or JSON ([{\"address\": ..., \"amount\": ...}])")
+	fmt.Println("      -mine, -encrypt, -connect, -addnode, -seeds, -coinselect and -input work as for send")
+	fmt.Println(" createrawtx -from FROM -to ADDRESS:AMOUNT (repeatable) -file FILE -out TX - Write an unsigned transaction for offline signing")
+	fmt.Println("      -change ADDRESS - Where change goes (default a new change address, or FROM when it is watch-only)")
+	fmt.Println("      -coinselect and -input work as for send")
+	fmt.Println(" signrawtx -in TX -out TX - Sign a transaction with the wallet file alone, no chain needed")
+	fmt.Println(" sendrawtx -in TX - Broadcast a signed transaction")
+	fmt.Println("      -encrypt, -connect, -addnode and -seeds work as for send")
 	fmt.Println(" createwallet - Creates a new Wallet")
+	fmt.Println("      -mnemonic -words 12|24 - Start the wallet from a new recovery phrase")
+	fmt.Println(" restorewallet -gap N - Rebuild the wallet from a recovery phrase read from stdin, scanning N unused addresses ahead")
 	fmt.Println(" listaddrs - List the addresses in our wallet file ")
+	fmt.Println(" setlabel -addr ADDRESS -label LABEL - Label a wallet address, an empty label removes it")
+	fmt.Println(" getwalletbalance - Sum the confirmed, unconfirmed and spendable balance of every wallet address")
+	fmt.Println(" listunspent - List the unspent outputs of the wallet")
+	fmt.Println(" importwatch -addr ADDRESS | -pubkey HEX | -xpub XPUB [-gap N] - Track addresses without their private keys")
+	fmt.Println(" gethistory -addr ADDRESS - List the confirmed transactions of an address")
+	fmt.Println(" dumpprivkey -addr ADDRESS - Print the private key of an address in a portable format")
+	fmt.Println(" importprivkey -rescan - Import a private key read from stdin and show its balance, -rescan=false skips the balance")
+	fmt.Println(" getxpub - Print the extended public key of the wallet's account")
+	fmt.Println(" deriveaddr -xpub XPUB -chain 0|1 -index N - Derive a receive or change address from an extended key")
+	fmt.Println(" encryptwallet - Encrypt the wallet keys with a passphrase read from stdin")
+	fmt.Println(" walletpassphrase -timeout SECONDS - Unlock the wallet of the running node for sends")
+	fmt.Println(" walletlock - Lock the wallet of the running node again")
 	fmt.Println(" reindexutxo - Rebuild the UTXO set ")
+	fmt.Println(" repair [-force] - Clear stale database locks left by a crashed node, -force even if the lock's pid is alive")
+	fmt.Println(" nodeid - Print the identity used by the encrypted transport")
 	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env -miner enables mining ")
+	fmt.Println("           -listen HOST:PORT - Address to listen on (default :NODE_ID)")
+	fmt.Println("           -externaladdr HOST[:PORT] - Address advertised to peers")
+	fmt.Println("           -connect HOST:PORT - Only connect to the given peers (repeatable)")
+	fmt.Println("           -addnode HOST:PORT - Add a peer to connect to (repeatable)")
+	fmt.Println("           -encrypt - Use the encrypted peer transport")
+	fmt.Println("           -allowpeer ID - Only accept peers with the given identity (repeatable)")
+	fmt.Println("           -rpcaddr HOST:PORT - JSON-RPC listen address (default 127.0.0.1:NODE_ID+1000)")
+	fmt.Println("           -rpcuser USER -rpcpassword PASS - JSON-RPC basic auth, a cookie file is always written")
+	fmt.Println("           -explorer HOST:PORT - Serve the REST block explorer on the given address")
+	fmt.Println("                                 /events (SSE) and /events/ws stream chain events, ?address= and ?type= filter them")
+	fmt.Println("           -metrics HOST:PORT - Serve Prometheus metrics on /metrics at the given address")
+	fmt.Println("           -loglevel LEVELS - Default level and per subsystem overrides, e.g. info,net=debug,miner=warn")
+	fmt.Println("                              subsystems: chain, net, mempool, miner, wallet, rpc, api (LOG_LEVEL env sets the default)")
+	fmt.Println("           -logjson - Write logs as JSON")
+	fmt.Println("           -logfile FILE - Also write logs to FILE (default ./tmp/node_NODE_ID.log, empty disables)")
+	fmt.Println("           -rebroadcast N - Broadcast wallet transactions again after N blocks unconfirmed (default 3)")
+	fmt.Println("           -seeds FILE - File with one seed address per line (default ./tmp/seeds_NODE_ID.txt)")
 }
 
 func (cli *CommandLine) validateArgs() {
 	if len(os.Args) < 2 {
 		cli.printUsage()
-		runtime.Goexit()
+		exit(2)
+	}
+}
+
+// daemon returns an RPC client when a node for nodeId is running, so commands
+// don't have to open the database it holds.
+func (cli *CommandLine) daemon(nodeId string) *rpc.Client {
+	client, err := rpc.Detect(nodeId)
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	return client
+}
+
+func (cli *CommandLine) requireOffline(nodeId string) {
+	if client := cli.daemon(nodeId); client != nil {
+		fmt.Printf("Node %s is running (RPC %s), stop it before running this command\n", nodeId, client.Addr())
+		exit(1)
+	}
+}
+
+func (cli *CommandLine) printChainRPC(client *rpc.Client) {
+	var hash string
+	handlers.HandleErr(client.Call("getbestblockhash", &hash))
+
+	for hash != "" {
+		var b rpc.BlockResult
+		handlers.HandleErr(client.Call("getblock", &b, hash))
+
+		fmt.Printf("Previous Hash: %s\n", b.PrevHash)
+		fmt.Printf("Hash: %s\n", b.Hash)
+		fmt.Printf("Height: %d\n\n", b.Height)
+
+		for _, tx := range b.Transactions {
+			fmt.Printf("-- Transaction %s:\n", tx.TxID)
+			for i, in := range tx.Inputs {
+				fmt.Printf("	Input %d: %s:%d\n", i, in.TxID, in.Vout)
+			}
+			for i, out := range tx.Outputs {
+				fmt.Printf("	Output %d: %d to %s\n", i, out.Value, out.Address)
+			}
+		}
+		fmt.Println()
+
+		hash = b.PrevHash
 	}
 }
 
 func (cli *CommandLine) printChain(nodeId string) {
-	chain := blockchain.ContinueBlockChain(nodeId)
-	defer chain.Database.Close()
+	if client := cli.daemon(nodeId); client != nil {
+		cli.printChainRPC(client)
+		return
+	}
+
+	chain := openChain(nodeId)
+	defer chain.Close()
 	iter := chain.Iterator()
 
 	for {
@@ -59,25 +275,34 @@ func (cli *CommandLine) printChain(nodeId string) {
 }
 
 func (cli *CommandLine) createBlockChain(addr, nodeId string) {
-	if !wallet.ValidateAddress(addr) {
-		log.Panic("Address in not valid")
-	}
+	validateAddress(addr)
+
+	cli.requireOffline(nodeId)
 
-	chain := blockchain.InitBlockChain(addr, nodeId)
+	chain, err := blockchain.InitBlockChain(addr, nodeId)
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
 	utxoSet := blockchain.UTXOSet{BlockChain: chain}
 	utxoSet.Reindex()
-	chain.Database.Close()
+	chain.Close()
 	fmt.Println("Finished!")
 }
 
 func (cli *CommandLine) getBalance(addr, nodeId string) {
-	if !wallet.ValidateAddress(addr) {
-		log.Panic("Address in not valid")
+	validateAddress(addr)
+
+	if client := cli.daemon(nodeId); client != nil {
+		var balance int
+		handlers.HandleErr(client.Call("getbalance", &balance, addr))
+		fmt.Printf("Balance of %s: %d\n", addr, balance)
+		return
 	}
 
-	chain := blockchain.ContinueBlockChain(nodeId)
+	chain := openChain(nodeId)
 	utxoSet := blockchain.UTXOSet{BlockChain: chain}
-	defer chain.Database.Close()
+	defer chain.Close()
 
 	balance := 0
 	pubKeyHash := wallet.Base58Decode([]byte(addr))
@@ -91,29 +316,247 @@ func (cli *CommandLine) getBalance(addr, nodeId string) {
 	fmt.Printf("Balance of %s: %d\n", addr, balance)
 }
 
-func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow bool) {
-	if !wallet.ValidateAddress(from) || !wallet.ValidateAddress(to) {
-		log.Panic("Address in not valid")
+func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow bool, relay network.Config, strategy string, inputs []string) {
+	validateAddress(to)
+	cli.pay(from, []blockchain.Payment{{Address: to, Amount: amount}}, nodeId, mineNow, relay, strategy, inputs)
+}
+
+// sendMany pays the recipients given with -to and those read from file in
+// one transaction.
+func (cli *CommandLine) sendMany(from string, to []string, file, nodeId string, mineNow bool, relay network.Config, strategy string, inputs []string) {
+	payments := collectPayments(to, file)
+	total, _ := blockchain.ValidatePayments(payments)
+	fmt.Printf("Paying %d recipients a total of %d\n", len(payments), total)
+
+	cli.pay(from, payments, nodeId, mineNow, relay, strategy, inputs)
+}
+
+// collectPayments reads the recipients given with -to and those in file, and
+// exits unless they are all valid.
+func collectPayments(to []string, file string) []blockchain.Payment {
+	var payments []blockchain.Payment
+	for _, arg := range to {
+		payment, err := parsePayment(arg)
+		if err != nil {
+			fmt.Println(err)
+			exit(1)
+		}
+		payments = append(payments, payment)
+	}
+	if file != "" {
+		read, err := readPayments(file)
+		if err != nil {
+			fmt.Println(err)
+			exit(1)
+		}
+		payments = append(payments, read...)
+	}
+
+	if _, err := blockchain.ValidatePayments(payments); err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	return payments
+}
+
+func coinSelector(strategy string, inputs []string) blockchain.CoinSelector {
+	var outpoints []blockchain.Outpoint
+	for _, in := range inputs {
+		outpoint, err := blockchain.ParseOutpoint(in)
+		if err != nil {
+			fmt.Println(err)
+			exit(1)
+		}
+		outpoints = append(outpoints, outpoint)
+	}
+	selector, err := blockchain.NewCoinSelector(strategy, outpoints)
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	return selector
+}
+
+// createRawTx is the first step of offline signing. It runs where the chain
+// is, typically on a watch-only wallet.
+func (cli *CommandLine) createRawTx(from string, to []string, file, change, out, nodeId, strategy string, inputs []string) {
+	validateAddress(from)
+	if change != "" {
+		validateAddress(change)
+	}
+	payments := collectPayments(to, file)
+	selector := coinSelector(strategy, inputs)
+
+	var raw *blockchain.RawTx
+	if client := cli.daemon(nodeId); client != nil {
+		raw = &blockchain.RawTx{}
+		call(client, "createrawtransaction", raw, from, payments, change, strategy, inputs)
+	} else {
+		chain := openChain(nodeId)
+		defer chain.Close()
+
+		updateWallets(nodeId, "", func(wallets *wallet.Wallets) error {
+			var err error
+			raw, err = rpc.NewRawTransaction(chain, wallets, from, payments, change, selector)
+			return err
+		})
+	}
+
+	handlers.HandleErr(raw.WriteFile(out))
+	if out != "" {
+		fmt.Printf("Unsigned transaction written to %s\n", out)
+	}
+}
+
+// signRawTx is the second step of offline signing and only reads the wallet
+// file, so it works on a machine without the chain. What is being signed is
+// printed to stderr for review.
+func (cli *CommandLine) signRawTx(in, out, nodeId string) {
+	raw, err := blockchain.ReadRawTx(in)
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	tx, prevTxs, err := raw.Decode()
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+
+	spent := blockchain.InputValue(tx, prevTxs)
+	fmt.Fprintf(os.Stderr, "Spending %d from %d inputs\n", spent, len(tx.Inputs))
+	paid := 0
+	for _, output := range tx.Outputs {
+		fmt.Fprintf(os.Stderr, "  %d to %s\n", output.Value, wallet.HashToAddress(output.PubKeyHash))
+		paid += output.Value
+	}
+	if paid != spent {
+		fmt.Printf("Outputs pay %d but inputs are worth %d\n", paid, spent)
+		exit(1)
+	}
+
+	signed, err := blockchain.SignRawTx(raw, loadWallets(nodeId, true))
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	handlers.HandleErr(signed.WriteFile(out))
+	if out != "" {
+		fmt.Printf("Signed transaction written to %s\n", out)
+	}
+}
+
+// sendRawTx broadcasts a transaction signed by signrawtx.
+func (cli *CommandLine) sendRawTx(in, nodeId string, relay network.Config) {
+	raw, err := blockchain.ReadRawTx(in)
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	tx, _, err := raw.Decode()
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	if !tx.Signed() {
+		fmt.Println("Transaction is not signed, run signrawtx first")
+		exit(1)
+	}
+
+	if client := cli.daemon(nodeId); client != nil {
+		var txID string
+		call(client, "sendrawtransaction", &txID, hex.EncodeToString(tx.Serialize()))
+		fmt.Printf("tx %s sent through node at %s\n", txID, client.Addr())
+		return
 	}
 
-	chain := blockchain.ContinueBlockChain(nodeId)
+	chain := openChain(nodeId)
+	defer chain.Close()
+	if !chain.VerifyTransaction(tx) {
+		fmt.Println("Transaction does not verify against the chain")
+		exit(1)
+	}
+
+	// Only a transaction some peer took is worth waiting for.
+	if err := cli.relayTx(relay, tx); err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+
+	updateWallets(nodeId, "", func(wallets *wallet.Wallets) error {
+		for _, input := range tx.Inputs {
+			if wallets.GetAddress(string(wallet.HashToAddress(wallet.PublicKeyHash(input.PubKey)))) != nil {
+				wallets.AddPending(hex.EncodeToString(tx.ID), blockchain.NewPendingTx(tx, chain.GetBestHeight()))
+				break
+			}
+		}
+		return nil
+	})
+	fmt.Printf("tx %x sent\n", tx.ID)
+}
+
+func (cli *CommandLine) pay(from string, payments []blockchain.Payment, nodeId string, mineNow bool, relay network.Config, strategy string, inputs []string) {
+	validateAddress(from)
+	selector := coinSelector(strategy, inputs)
+
+	if client := cli.daemon(nodeId); client != nil {
+		var txID string
+		call(client, "sendmany", &txID, from, payments, mineNow, strategy, inputs)
+		fmt.Printf("tx %s sent through node at %s\n", txID, client.Addr())
+		fmt.Println("Success!")
+		return
+	}
+
+	chain := openChain(nodeId)
 	utxoSet := blockchain.UTXOSet{BlockChain: chain}
-	defer chain.Database.Close()
+	defer chain.Close()
 
-	wallets, err := wallet.CreateWallets(nodeId)
-	handlers.HandleErr(err)
-	wallet := wallets.GetAddress(from)
+	var tx *blockchain.Transaction
+	updateWallets(nodeId, askPassphrase(nodeId), func(wallets *wallet.Wallets) error {
+		w := wallets.GetAddress(from)
+		if w == nil {
+			return fmt.Errorf("Address %s is not in the wallet", from)
+		}
+		if w.WatchOnly() {
+			return fmt.Errorf("Address %s is watch-only", from)
+		}
+
+		change, err := wallets.ChangeAddress()
+		if err != nil {
+			return err
+		}
+
+		utxoSet.ResolvePending(wallets)
+		tx, err = blockchain.NewPaymentTransaction(w, payments, change, &utxoSet,
+			blockchain.SkipLocked(selector, wallets.LockedInputs()))
+		if err != nil {
+			return err
+		}
+		if len(tx.Outputs) == len(payments) {
+			wallets.ReleaseChange(change)
+		}
+		if !mineNow {
+			wallets.AddPending(hex.EncodeToString(tx.ID), blockchain.NewPendingTx(tx, chain.GetBestHeight()))
+		}
+		return nil
+	})
 
-	log.Print("initialize new Transaction")
-	tx := blockchain.NewTransaction(wallet, to, amount, &utxoSet)
 	if mineNow {
-		log.Println("mine: true")
 		cbTx := blockchain.CoinbaseTx(from, "")
 		txs := []*blockchain.Transaction{cbTx, tx}
-		block := chain.MineBlock(txs)
+		block, err := chain.MineBlockContext(context.Background(), txs)
+		handlers.HandleErr(err)
 		utxoSet.Update(block)
 	} else {
-		network.SendTx(network.KnownNodes[0], tx)
+		if err := cli.relayTx(relay, tx); err != nil {
+			// Nobody got it, so the wallet must not wait for it.
+			updateWallets(nodeId, "", func(wallets *wallet.Wallets) error {
+				wallets.RemovePending(hex.EncodeToString(tx.ID))
+				return nil
+			})
+			fmt.Println(err)
+			exit(1)
+		}
 		fmt.Println("tx sent")
 	}
 
@@ -138,13 +581,115 @@ func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow
 // 	fmt.Println("Success!")
 // }
 
-func (cli *CommandLine) createWallet(nodeId string) {
-	ws, _ := wallet.CreateWallets(nodeId)
-	addr := ws.AddWallet()
+func (cli *CommandLine) createWallet(nodeId string, mnemonic bool, words int) {
+	var phrase, addr string
+	updateWallets(nodeId, askPassphrase(nodeId), func(ws *wallet.Wallets) error {
+		if mnemonic {
+			var err error
+			if phrase, err = wallet.NewMnemonic(words / 3 * 32); err != nil {
+				return err
+			}
+			seed, err := wallet.MnemonicSeed(phrase, "")
+			handlers.HandleErr(err)
+			if err := ws.SetSeed(seed); err != nil {
+				return err
+			}
+		}
 
-	ws.SaveFile(nodeId)
+		var err error
+		addr, err = ws.AddWallet()
+		return err
+	})
 
 	fmt.Printf("New address is: %s\n", addr)
+	if mnemonic {
+		fmt.Println("Write down the recovery phrase, restorewallet rebuilds every key from it:")
+		fmt.Println(phrase)
+	}
+}
+
+func (cli *CommandLine) restoreWallet(nodeId string, gap int) {
+	cli.requireOffline(nodeId)
+
+	ws := loadWallets(nodeId, false)
+	if len(ws.Wallets) > 0 || ws.HD != nil {
+		fmt.Printf("Wallet of node %s already has keys, move its wallet file away first\n", nodeId)
+		exit(1)
+	}
+
+	used := map[string]bool{}
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	switch {
+	case errors.Is(err, blockchain.ErrNoChain):
+		fmt.Println("No blockchain found, restoring without scanning for used addresses")
+	case err != nil:
+		fmt.Println(err)
+		exit(1)
+	default:
+		used = chain.UsedPubKeyHashes()
+		chain.Close()
+	}
+
+	phrase := readPassphrase("Recovery phrase: ")
+	restored, err := wallet.RestoreWallets(phrase, "", gap, func(pubKeyHash []byte) bool {
+		return used[hex.EncodeToString(pubKeyHash)]
+	})
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	updateWallets(nodeId, "", func(ws *wallet.Wallets) error {
+		if len(ws.Wallets) > 0 || ws.HD != nil {
+			return fmt.Errorf("Wallet of node %s already has keys, move its wallet file away first", nodeId)
+		}
+		*ws = *restored
+		return nil
+	})
+
+	fmt.Printf("Restored %d addresses\n", len(restored.Wallets))
+}
+
+func (cli *CommandLine) dumpPrivKey(addr, nodeId string) {
+	ws := loadWallets(nodeId, true)
+	w := ws.GetAddress(addr)
+	if w == nil {
+		fmt.Printf("Address %s is not in the wallet\n", addr)
+		exit(1)
+	}
+
+	key, err := w.EncodePrivateKey()
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	fmt.Println(key)
+}
+
+func (cli *CommandLine) importPrivKey(nodeId string, rescan bool) {
+	passphrase := askPassphrase(nodeId)
+	w, err := wallet.DecodePrivateKey(strings.TrimSpace(readPassphrase("Private key: ")))
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+
+	var addr string
+	updateWallets(nodeId, passphrase, func(ws *wallet.Wallets) error {
+		addr, err = ws.ImportWallet(w)
+		return err
+	})
+	fmt.Printf("Imported address %s\n", addr)
+
+	if !rescan {
+		return
+	}
+
+	// The UTXO set holds the outputs of every address, so the key's coins
+	// are already there and only need looking up.
+	if cli.daemon(nodeId) == nil && !handlers.DbExist(fmt.Sprintf(handlers.DbPath, nodeId)) {
+		return
+	}
+	cli.getBalance(addr, nodeId)
 }
 
 func (cli *CommandLine) listAddrs(nodeId string) {
@@ -152,13 +697,234 @@ func (cli *CommandLine) listAddrs(nodeId string) {
 	addrs := ws.GetAllAddresses()
 
 	for _, addr := range addrs {
-		fmt.Println(addr)
+		line := addr
+		if label := ws.Label(addr); label != "" {
+			line += fmt.Sprintf(" %q", label)
+		}
+		if ws.GetAddress(addr).WatchOnly() {
+			line += " (watch-only)"
+		}
+		fmt.Println(line)
+	}
+}
+
+func (cli *CommandLine) setLabel(addr, label, nodeId string) {
+	validateAddress(addr)
+
+	updateWallets(nodeId, "", func(ws *wallet.Wallets) error {
+		return ws.SetLabel(addr, label)
+	})
+}
+
+// getWalletBalance asks the running node, which also counts the mempool.
+// Offline only confirmed outputs are known.
+func (cli *CommandLine) getWalletBalance(nodeId string) {
+	var balance rpc.WalletBalanceResult
+	if client := cli.daemon(nodeId); client != nil {
+		call(client, "getwalletbalance", &balance)
+	} else {
+		chain := openChain(nodeId)
+		balance = rpc.NewWalletBalance(chain, loadWallets(nodeId, false), nil)
+		chain.Close()
+	}
+
+	fmt.Printf("Confirmed: %d\n", balance.Confirmed)
+	fmt.Printf("Unconfirmed: %d\n", balance.Unconfirmed)
+	fmt.Printf("Available: %d\n", balance.Available)
+	if balance.Pending > 0 {
+		fmt.Printf("Pending transactions: %d\n", balance.Pending)
+	}
+	if balance.WatchOnlyConfirmed != 0 || balance.WatchOnlyUnconfirmed != 0 {
+		fmt.Printf("Watch-only confirmed: %d\n", balance.WatchOnlyConfirmed)
+		fmt.Printf("Watch-only unconfirmed: %d\n", balance.WatchOnlyUnconfirmed)
 	}
 }
 
+func (cli *CommandLine) listUnspent(nodeId string) {
+	var unspent []rpc.UnspentResult
+	if client := cli.daemon(nodeId); client != nil {
+		call(client, "listunspent", &unspent)
+	} else {
+		chain := openChain(nodeId)
+		unspent = rpc.NewUnspentResults(chain, loadWallets(nodeId, false))
+		chain.Close()
+	}
+
+	for _, utxo := range unspent {
+		addr := utxo.Address
+		if utxo.Label != "" {
+			addr += fmt.Sprintf(" %q", utxo.Label)
+		}
+		if utxo.WatchOnly {
+			addr += " (watch-only)"
+		}
+		if utxo.Locked {
+			addr += " (locked)"
+		}
+		fmt.Printf("%s:%d %d to %s (%d confirmations)\n", utxo.TxID, utxo.Vout, utxo.Amount, addr, utxo.Confirmations)
+	}
+}
+
+// importWatch adds an address, a public key or the addresses of an extended
+// public key to the wallet without their private keys.
+func (cli *CommandLine) importWatch(nodeId, addr, pubKey, xpub string, gap int) {
+	if xpub != "" {
+		cli.requireOffline(nodeId)
+
+		used := map[string]bool{}
+		chain, err := blockchain.ContinueBlockChain(nodeId)
+		if err == nil {
+			used = chain.UsedPubKeyHashes()
+			chain.Close()
+		} else if !errors.Is(err, blockchain.ErrNoChain) {
+			fmt.Println(err)
+			exit(1)
+		}
+
+		var added []string
+		updateWallets(nodeId, "", func(ws *wallet.Wallets) error {
+			added, err = ws.WatchXPub(xpub, gap, func(pubKeyHash []byte) bool {
+				return used[hex.EncodeToString(pubKeyHash)]
+			})
+			return err
+		})
+		fmt.Printf("Watching %d new addresses of the extended key\n", len(added))
+		return
+	}
+
+	var w *wallet.Wallet
+	var err error
+	if pubKey != "" {
+		var key []byte
+		if key, err = hex.DecodeString(pubKey); err == nil {
+			w, err = wallet.NewWatchOnlyPubKey(key)
+		}
+	} else {
+		w, err = wallet.NewWatchOnly(addr)
+	}
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+
+	var added string
+	updateWallets(nodeId, "", func(ws *wallet.Wallets) error {
+		added, err = ws.ImportWallet(w)
+		return err
+	})
+	fmt.Printf("Watching address %s\n", added)
+}
+
+func (cli *CommandLine) getHistory(addr, nodeId string) {
+	validateAddress(addr)
+
+	var history []rpc.HistoryResult
+	if client := cli.daemon(nodeId); client != nil {
+		call(client, "getaddresshistory", &history, addr)
+	} else {
+		chain := openChain(nodeId)
+		history = rpc.NewHistoryResults(chain.AddressHistory(wallet.AddressToHash(addr)), chain.GetBestHeight())
+		chain.Close()
+	}
+
+	for _, entry := range history {
+		fmt.Printf("%s height %d (%d confirmations): received %d, sent %d\n",
+			entry.TxID, entry.Height, entry.Confirmations, entry.Received, entry.Sent)
+	}
+}
+
+func (cli *CommandLine) encryptWallet(nodeId string) {
+	passphrase := readPassphrase("New passphrase: ")
+	if passphrase != readPassphrase("Repeat passphrase: ") {
+		fmt.Println("Passphrases do not match")
+		exit(1)
+	}
+
+	if client := cli.daemon(nodeId); client != nil {
+		var msg string
+		call(client, "encryptwallet", &msg, passphrase)
+		fmt.Println(msg)
+		return
+	}
+
+	updateWallets(nodeId, "", func(ws *wallet.Wallets) error {
+		if err := ws.Encrypt(passphrase); err != nil {
+			return err
+		}
+		ws.Lock()
+		return nil
+	})
+
+	fmt.Println("Wallet encrypted")
+}
+
+// call runs an RPC whose errors are expected user errors, such as a locked
+// wallet, and reports them without a stack trace.
+func call(client *rpc.Client, method string, result any, params ...any) {
+	if err := client.Call(method, result, params...); err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+}
+
+// requireDaemon returns the RPC client of the running node for commands that
+// only make sense against one.
+func (cli *CommandLine) requireDaemon(nodeId, command string) *rpc.Client {
+	client := cli.daemon(nodeId)
+	if client == nil {
+		fmt.Printf("%s needs a running node, offline commands ask for the passphrase instead\n", command)
+		exit(1)
+	}
+	return client
+}
+
+func (cli *CommandLine) walletPassphrase(nodeId string, timeout int) {
+	client := cli.requireDaemon(nodeId, "walletpassphrase")
+	passphrase := readPassphrase("Wallet passphrase: ")
+
+	call(client, "walletpassphrase", nil, passphrase, timeout)
+	fmt.Printf("Wallet unlocked for %d seconds\n", timeout)
+}
+
+func (cli *CommandLine) walletLock(nodeId string) {
+	client := cli.requireDaemon(nodeId, "walletlock")
+
+	call(client, "walletlock", nil)
+	fmt.Println("Wallet locked")
+}
+
+func (cli *CommandLine) getXPub(nodeId string) {
+	ws := loadWallets(nodeId, false)
+	if ws.HD == nil {
+		fmt.Println("Wallet has no HD chain yet, create an address first")
+		exit(1)
+	}
+
+	fmt.Println(ws.HD.XPub)
+}
+
+// deriveAddr derives an address from an extended key, e.g. the account xpub
+// of a watch-only wallet.
+func (cli *CommandLine) deriveAddr(xkey string, chain, index int) {
+	key, err := wallet.ParseExtendedKey(xkey)
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	child, err := key.Derive(fmt.Sprintf("m/%d/%d", chain, index))
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+
+	fmt.Printf("%s\n", child.Wallet().Address())
+}
+
 func (cli *CommandLine) reindexUTXO(nodeId string) {
-	chain := blockchain.ContinueBlockChain(nodeId)
-	defer chain.Database.Close()
+	cli.requireOffline(nodeId)
+
+	chain := openChain(nodeId)
+	defer chain.Close()
 
 	utxoSet := blockchain.UTXOSet{BlockChain: chain}
 	utxoSet.Reindex()
@@ -167,36 +933,167 @@ func (cli *CommandLine) reindexUTXO(nodeId string) {
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
 
-func (cli *CommandLine) StartNode(nodeId, minerAddr string) {
-	fmt.Printf("Starting node %s\n", nodeId)
+// relayTx sends tx to the peers of relay without a running node.
+func (cli *CommandLine) relayTx(relay network.Config, tx *blockchain.Transaction) error {
+	if relay.Encrypt {
+		cli.useSecureTransport(relay.NodeID)
+	}
+	peers, err := network.RelayPeers(relay)
+	if err != nil {
+		return err
+	}
+	return network.BroadcastTx(peers, tx)
+}
 
-	if len(minerAddr) > 0 {
-		if wallet.ValidateAddress(minerAddr) {
-			fmt.Println("Mining is on. Addr to receive rewards: ", minerAddr)
+func (cli *CommandLine) useSecureTransport(nodeId string) {
+	identity, err := network.LoadIdentity(network.NodeKeyPath(nodeId))
+	handlers.HandleErr(err)
+	secure, err := network.NewSecureTransport(identity, nil)
+	handlers.HandleErr(err)
+	network.SetTransport(secure)
+}
+
+func (cli *CommandLine) nodeID(nodeId string) {
+	identity, err := network.LoadIdentity(network.NodeKeyPath(nodeId))
+	handlers.HandleErr(err)
+
+	fmt.Println(network.IdentityOf(identity.Public().(ed25519.PublicKey)))
+}
+
+func (cli *CommandLine) repair(nodeId string, force bool) {
+	cli.requireOffline(nodeId)
+
+	if err := blockchain.RepairBlockChain(nodeId, force); err != nil {
+		fmt.Println("Repair failed: ", err)
+		var locked *handlers.LockedError
+		if errors.As(err, &locked) && !force {
+			fmt.Printf("If pid %d is not a node, run repair -force\n", locked.PID)
+		}
+		exit(1)
+	}
+	fmt.Println("Database repaired")
+}
+
+func (cli *CommandLine) StartNode(cfg network.Config, rpcCfg rpc.Config, explorerAddr, metricsAddr string) {
+	nodeLog.Info("starting node", "node", cfg.NodeID)
+
+	if len(cfg.MinerAddr) > 0 {
+		if wallet.ValidateAddress(cfg.MinerAddr) {
+			nodeLog.Info("mining enabled", "reward", cfg.MinerAddr)
 		} else {
-			log.Panic("Wrong miner address.")
+			fmt.Println("Wrong miner address.")
+			exit(1)
+		}
+	}
+
+	// The first SIGINT or SIGTERM starts a clean shutdown, a second one
+	// kills the node.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	chain := openChain(cfg.NodeID)
+	defer chain.Close()
+
+	// The servers are shut down by StartServer, before it drains the node.
+	rpcServer := rpc.NewServer(chain, rpcCfg)
+	go func() {
+		if err := rpcServer.ListenAndServe(); err != nil {
+			nodeLog.Error("RPC server stopped", "err", err)
 		}
+	}()
+	cfg.Services = append(cfg.Services, rpcServer)
+
+	if explorerAddr != "" {
+		explorerServer := explorer.NewServer(chain, explorerAddr)
+		go func() {
+			if err := explorerServer.ListenAndServe(); err != nil {
+				nodeLog.Error("explorer stopped", "err", err)
+			}
+		}()
+		cfg.Services = append(cfg.Services, explorerServer)
 	}
-	network.StartServer(nodeId, minerAddr)
+
+	if metricsAddr != "" {
+		chain.RegisterMetrics()
+		metricsServer := metrics.NewServer(metricsAddr)
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil {
+				nodeLog.Error("metrics server stopped", "err", err)
+			}
+		}()
+		cfg.Services = append(cfg.Services, metricsServer)
+	}
+
+	if err := network.StartServer(ctx, chain, cfg); err != nil {
+		nodeLog.Error("node stopped with errors", "err", err)
+		if errors.Is(err, network.ErrDrainTimeout) {
+			// Closing the database under a running handler or RPC call
+			// corrupts it; exiting leaves it as a crash would, for repair
+			// to recover.
+			nodeLog.Warn("exiting without closing the database")
+			os.Exit(1)
+		}
+		exit(1)
+	}
+	nodeLog.Info("node stopped")
 }
 
-func (cli *CommandLine) Run() {
+// Run executes the command in os.Args and returns the process exit code.
+func (cli *CommandLine) Run() (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, ok := r.(exitCode)
+			if !ok {
+				panic(r)
+			}
+			code = int(c)
+		}
+	}()
+
 	cli.validateArgs()
 
 	nodeID := os.Getenv("NODE_ID")
 	if nodeID == "" {
-		fmt.Printf("NODE_ID env is not set!")
-		runtime.Goexit()
+		fmt.Println("NODE_ID env is not set!")
+		exit(1)
+	}
+
+	if err := logging.Setup(logging.Config{Levels: os.Getenv("LOG_LEVEL")}); err != nil {
+		fmt.Println(err)
+		exit(1)
 	}
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createbc", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtx", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtx", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtx", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
 	listAddrsCmd := flag.NewFlagSet("listaddrs", flag.ExitOnError)
+	setLabelCmd := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	getWalletBalanceCmd := flag.NewFlagSet("getwalletbalance", flag.ExitOnError)
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	importWatchCmd := flag.NewFlagSet("importwatch", flag.ExitOnError)
+	getHistoryCmd := flag.NewFlagSet("gethistory", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	nodeIDCmd := flag.NewFlagSet("nodeid", flag.ExitOnError)
+	repairCmd := flag.NewFlagSet("repair", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	walletPassphraseCmd := flag.NewFlagSet("walletpassphrase", flag.ExitOnError)
+	walletLockCmd := flag.NewFlagSet("walletlock", flag.ExitOnError)
+	getXPubCmd := flag.NewFlagSet("getxpub", flag.ExitOnError)
+	deriveAddrCmd := flag.NewFlagSet("deriveaddr", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("addr", "", "The address")
 	createBlockchainAddress := createBlockchainCmd.String("addr", "", "The created blockchain")
@@ -204,7 +1101,65 @@ func (cli *CommandLine) Run() {
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount sent")
 	sendMine := sendCmd.Bool("mine", false, "Mine immidiately on the same node")
+	sendRelay := relayFlags(sendCmd)
+	sendCoinSelect := sendCmd.String("coinselect", "", "Coin selection strategy: largest, smallest, bnb or random")
+	var sendInputs addrList
+	sendCmd.Var(&sendInputs, "input", "Output to spend as TXID:VOUT")
+	sendManyFrom := sendManyCmd.String("from", "", "source wallet address")
+	sendManyFile := sendManyCmd.String("file", "", "CSV or JSON file of recipients")
+	sendManyMine := sendManyCmd.Bool("mine", false, "Mine immidiately on the same node")
+	sendManyRelay := relayFlags(sendManyCmd)
+	sendManyCoinSelect := sendManyCmd.String("coinselect", "", "Coin selection strategy: largest, smallest, bnb or random")
+	var sendManyTo, sendManyInputs addrList
+	sendManyCmd.Var(&sendManyTo, "to", "Recipient as ADDRESS:AMOUNT")
+	sendManyCmd.Var(&sendManyInputs, "input", "Output to spend as TXID:VOUT")
+	createRawTxFrom := createRawTxCmd.String("from", "", "source wallet address")
+	createRawTxFile := createRawTxCmd.String("file", "", "CSV or JSON file of recipients")
+	createRawTxChange := createRawTxCmd.String("change", "", "Change address")
+	createRawTxOut := createRawTxCmd.String("out", "", "File to write the unsigned transaction to, stdout when empty")
+	createRawTxCoinSelect := createRawTxCmd.String("coinselect", "", "Coin selection strategy: largest, smallest, bnb or random")
+	var createRawTxTo, createRawTxInputs addrList
+	createRawTxCmd.Var(&createRawTxTo, "to", "Recipient as ADDRESS:AMOUNT")
+	createRawTxCmd.Var(&createRawTxInputs, "input", "Output to spend as TXID:VOUT")
+	signRawTxIn := signRawTxCmd.String("in", "", "Unsigned transaction file")
+	signRawTxOut := signRawTxCmd.String("out", "", "File to write the signed transaction to, stdout when empty")
+	sendRawTxIn := sendRawTxCmd.String("in", "", "Signed transaction file")
+	sendRawTxRelay := relayFlags(sendRawTxCmd)
+	importWatchAddress := importWatchCmd.String("addr", "", "Address to watch")
+	importWatchPubKey := importWatchCmd.String("pubkey", "", "Hex encoded public key to watch")
+	importWatchXPub := importWatchCmd.String("xpub", "", "Extended public key whose addresses to watch")
+	importWatchGap := importWatchCmd.Int("gap", wallet.DefaultGapLimit, "Unused addresses in a row before the xpub scan stops")
+	getHistoryAddress := getHistoryCmd.String("addr", "", "The address")
+	setLabelAddress := setLabelCmd.String("addr", "", "The address")
+	setLabelLabel := setLabelCmd.String("label", "", "Label for the address, empty removes it")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("addr", "", "The address")
+	importPrivKeyRescan := importPrivKeyCmd.Bool("rescan", true, "Show the balance of the imported address")
+	repairForce := repairCmd.Bool("force", false, "Remove the lock even if its pid belongs to a live process")
+	createWalletMnemonic := createWalletCmd.Bool("mnemonic", false, "Start the wallet from a new recovery phrase")
+	createWalletWords := createWalletCmd.Int("words", 12, "Number of words in the recovery phrase")
+	restoreWalletGap := restoreWalletCmd.Int("gap", wallet.DefaultGapLimit, "Unused addresses in a row before the scan stops")
+	deriveAddrXPub := deriveAddrCmd.String("xpub", "", "Extended key to derive from")
+	deriveAddrChain := deriveAddrCmd.Int("chain", 0, "0 for receive, 1 for change addresses")
+	deriveAddrIndex := deriveAddrCmd.Int("index", 0, "Address index")
+	walletPassphraseTimeout := walletPassphraseCmd.Int("timeout", 60, "Seconds the wallet stays unlocked")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining node and send reward to the miner")
+	startNodeListen := startNodeCmd.String("listen", "", "Address to listen on, defaults to :NODE_ID")
+	startNodeExternal := startNodeCmd.String("externaladdr", "", "Address advertised to peers")
+	startNodeRebroadcast := startNodeCmd.Int("rebroadcast", network.DefaultRebroadcastBlocks, "Blocks a wallet transaction may stay unconfirmed before it is broadcast again")
+	startNodeSeeds := startNodeCmd.String("seeds", "", "File with seed addresses to bootstrap from")
+	startNodeEncrypt := startNodeCmd.Bool("encrypt", false, "Use the encrypted peer transport")
+	startNodeRPCAddr := startNodeCmd.String("rpcaddr", "", "JSON-RPC listen address")
+	startNodeRPCUser := startNodeCmd.String("rpcuser", "", "JSON-RPC user name")
+	startNodeRPCPassword := startNodeCmd.String("rpcpassword", "", "JSON-RPC password")
+	startNodeExplorer := startNodeCmd.String("explorer", "", "REST explorer listen address, disabled when empty")
+	startNodeMetrics := startNodeCmd.String("metrics", "", "Prometheus metrics listen address, disabled when empty")
+	startNodeLogLevel := startNodeCmd.String("loglevel", os.Getenv("LOG_LEVEL"), "Log levels, e.g. info,net=debug,miner=warn")
+	startNodeLogJSON := startNodeCmd.Bool("logjson", false, "Write logs as JSON")
+	startNodeLogFile := startNodeCmd.String("logfile", fmt.Sprintf(logging.LogFile, nodeID), "Log file, disabled when empty")
+	var startNodeConnect, startNodeAddNode, startNodeAllowPeer addrList
+	startNodeCmd.Var(&startNodeConnect, "connect", "Connect only to the given peers")
+	startNodeCmd.Var(&startNodeAddNode, "addnode", "Add a peer to connect to")
+	startNodeCmd.Var(&startNodeAllowPeer, "allowpeer", "Only accept peers with the given identity")
 
 	switch os.Args[1] {
 	case "getbalance":
@@ -216,12 +1171,48 @@ func (cli *CommandLine) Run() {
 	case "send":
 		err := sendCmd.Parse(os.Args[2:])
 		handlers.HandleErr(err)
+	case "sendmany":
+		err := sendManyCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "createrawtx":
+		err := createRawTxCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "signrawtx":
+		err := signRawTxCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "sendrawtx":
+		err := sendRawTxCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
 	case "createwallet":
 		err := createWalletCmd.Parse(os.Args[2:])
 		handlers.HandleErr(err)
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
 	case "listaddrs":
 		err := listAddrsCmd.Parse(os.Args[2:])
 		handlers.HandleErr(err)
+	case "setlabel":
+		err := setLabelCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "getwalletbalance":
+		err := getWalletBalanceCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "listunspent":
+		err := listUnspentCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "importwatch":
+		err := importWatchCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "gethistory":
+		err := getHistoryCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "dumpprivkey":
+		err := dumpPrivKeyCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "importprivkey":
+		err := importPrivKeyCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
 	case "reindexutxo":
 		err := reindexUTXOCmd.Parse(os.Args[2:])
 		handlers.HandleErr(err)
@@ -231,15 +1222,36 @@ func (cli *CommandLine) Run() {
 	case "printchain":
 		err := printChainCmd.Parse(os.Args[2:])
 		handlers.HandleErr(err)
+	case "nodeid":
+		err := nodeIDCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "repair":
+		err := repairCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "walletpassphrase":
+		err := walletPassphraseCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "walletlock":
+		err := walletLockCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "getxpub":
+		err := getXPubCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
+	case "deriveaddr":
+		err := deriveAddrCmd.Parse(os.Args[2:])
+		handlers.HandleErr(err)
 	default:
 		cli.printUsage()
-		runtime.Goexit()
+		exit(2)
 	}
 
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
-			runtime.Goexit()
+			exit(2)
 		}
 		cli.getBalance(*getBalanceAddress, nodeID)
 	}
@@ -247,7 +1259,7 @@ func (cli *CommandLine) Run() {
 	if createBlockchainCmd.Parsed() {
 		if *createBlockchainAddress == "" {
 			createBlockchainCmd.Usage()
-			runtime.Goexit()
+			exit(2)
 		}
 		cli.createBlockChain(*createBlockchainAddress, nodeID)
 	}
@@ -255,19 +1267,116 @@ func (cli *CommandLine) Run() {
 	if sendCmd.Parsed() {
 		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
 			sendCmd.Usage()
-			runtime.Goexit()
+			exit(2)
+		}
+		sendRelay.NodeID = nodeID
+		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine, *sendRelay, *sendCoinSelect, sendInputs)
+	}
+
+	if sendManyCmd.Parsed() {
+		if *sendManyFrom == "" || (len(sendManyTo) == 0 && *sendManyFile == "") {
+			sendManyCmd.Usage()
+			exit(2)
+		}
+		sendManyRelay.NodeID = nodeID
+		cli.sendMany(*sendManyFrom, sendManyTo, *sendManyFile, nodeID, *sendManyMine, *sendManyRelay, *sendManyCoinSelect, sendManyInputs)
+	}
+
+	if createRawTxCmd.Parsed() {
+		if *createRawTxFrom == "" || (len(createRawTxTo) == 0 && *createRawTxFile == "") {
+			createRawTxCmd.Usage()
+			exit(2)
+		}
+		cli.createRawTx(*createRawTxFrom, createRawTxTo, *createRawTxFile, *createRawTxChange, *createRawTxOut, nodeID, *createRawTxCoinSelect, createRawTxInputs)
+	}
+
+	if signRawTxCmd.Parsed() {
+		if *signRawTxIn == "" {
+			signRawTxCmd.Usage()
+			exit(2)
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		cli.signRawTx(*signRawTxIn, *signRawTxOut, nodeID)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxIn == "" {
+			sendRawTxCmd.Usage()
+			exit(2)
+		}
+		sendRawTxRelay.NodeID = nodeID
+		cli.sendRawTx(*sendRawTxIn, nodeID, *sendRawTxRelay)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		if *createWalletWords%3 != 0 {
+			createWalletCmd.Usage()
+			exit(2)
+		}
+		cli.createWallet(nodeID, *createWalletMnemonic, *createWalletWords)
+	}
+
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletGap < 1 {
+			restoreWalletCmd.Usage()
+			exit(2)
+		}
+		cli.restoreWallet(nodeID, *restoreWalletGap)
 	}
 
 	if listAddrsCmd.Parsed() {
 		cli.listAddrs(nodeID)
 	}
 
+	if setLabelCmd.Parsed() {
+		if *setLabelAddress == "" {
+			setLabelCmd.Usage()
+			exit(2)
+		}
+		cli.setLabel(*setLabelAddress, *setLabelLabel, nodeID)
+	}
+
+	if getWalletBalanceCmd.Parsed() {
+		cli.getWalletBalance(nodeID)
+	}
+
+	if listUnspentCmd.Parsed() {
+		cli.listUnspent(nodeID)
+	}
+
+	if importWatchCmd.Parsed() {
+		given := 0
+		for _, v := range []string{*importWatchAddress, *importWatchPubKey, *importWatchXPub} {
+			if v != "" {
+				given++
+			}
+		}
+		if given != 1 || *importWatchGap < 1 {
+			importWatchCmd.Usage()
+			exit(2)
+		}
+		cli.importWatch(nodeID, *importWatchAddress, *importWatchPubKey, *importWatchXPub, *importWatchGap)
+	}
+
+	if getHistoryCmd.Parsed() {
+		if *getHistoryAddress == "" {
+			getHistoryCmd.Usage()
+			exit(2)
+		}
+		cli.getHistory(*getHistoryAddress, nodeID)
+	}
+
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			exit(2)
+		}
+		cli.dumpPrivKey(*dumpPrivKeyAddress, nodeID)
+	}
+
+	if importPrivKeyCmd.Parsed() {
+		cli.importPrivKey(nodeID, *importPrivKeyRescan)
+	}
+
 	if reindexUTXOCmd.Parsed() {
 		cli.reindexUTXO(nodeID)
 	}
@@ -276,13 +1385,78 @@ func (cli *CommandLine) Run() {
 		nodeID := os.Getenv("NODE_ID")
 		if nodeID == "" {
 			startNodeCmd.Usage()
-			runtime.Goexit()
+			exit(2)
+		}
+		err := logging.Setup(logging.Config{
+			Levels: *startNodeLogLevel,
+			JSON:   *startNodeLogJSON,
+			File:   *startNodeLogFile,
+		})
+		if err != nil {
+			fmt.Println(err)
+			exit(1)
 		}
-		cli.StartNode(nodeID, *startNodeMiner)
+		defer logging.Close()
+
+		cli.StartNode(network.Config{
+			NodeID:       nodeID,
+			MinerAddr:    *startNodeMiner,
+			ListenAddr:   *startNodeListen,
+			ExternalAddr: *startNodeExternal,
+			Connect:      startNodeConnect,
+			AddNodes:     startNodeAddNode,
+			SeedsFile:    *startNodeSeeds,
+			Encrypt:      *startNodeEncrypt,
+			AllowedPeers: startNodeAllowPeer,
+
+			RebroadcastBlocks: *startNodeRebroadcast,
+		}, rpc.Config{
+			NodeID:   nodeID,
+			Addr:     *startNodeRPCAddr,
+			User:     *startNodeRPCUser,
+			Password: *startNodeRPCPassword,
+		}, *startNodeExplorer, *startNodeMetrics)
 	}
 
 	if printChainCmd.Parsed() {
 		cli.printChain(nodeID)
 	}
 
+	if nodeIDCmd.Parsed() {
+		cli.nodeID(nodeID)
+	}
+
+	if repairCmd.Parsed() {
+		cli.repair(nodeID, *repairForce)
+	}
+
+	if encryptWalletCmd.Parsed() {
+		cli.encryptWallet(nodeID)
+	}
+
+	if walletPassphraseCmd.Parsed() {
+		if *walletPassphraseTimeout <= 0 {
+			walletPassphraseCmd.Usage()
+			exit(2)
+		}
+		cli.walletPassphrase(nodeID, *walletPassphraseTimeout)
+	}
+
+	if walletLockCmd.Parsed() {
+		cli.walletLock(nodeID)
+	}
+
+	if getXPubCmd.Parsed() {
+		cli.getXPub(nodeID)
+	}
+
+	if deriveAddrCmd.Parsed() {
+		if *deriveAddrXPub == "" || *deriveAddrChain < 0 || *deriveAddrIndex < 0 {
+			deriveAddrCmd.Usage()
+			exit(2)
+		}
+		cli.deriveAddr(*deriveAddrXPub, *deriveAddrChain, *deriveAddrIndex)
+	}
+
+	return 0
 }
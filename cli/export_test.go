@@ -0,0 +1,7 @@
+package cli
+
+var (
+	ParsePayment      = parsePayment
+	ReadPayments      = readPayments
+	DecodeCSVPayments = decodeCSVPayments
+)
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/FG420/go-block/blockchain"
+)
+
+// parsePayment reads a recipient written as ADDRESS:AMOUNT.
+func parsePayment(s string) (blockchain.Payment, error) {
+	addr, amount, ok := strings.Cut(s, ":")
+	if !ok {
+		return blockchain.Payment{}, fmt.Errorf("recipient %q must be ADDRESS:AMOUNT", s)
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(amount))
+	if err != nil {
+		return blockchain.Payment{}, fmt.Errorf("recipient %q has an invalid amount", s)
+	}
+	return blockchain.Payment{Address: strings.TrimSpace(addr), Amount: value}, nil
+}
+
+// readPayments reads recipients from a JSON array of {"address", "amount"}
+// objects, or from CSV with an address and an amount per line. Files without
+// a .json or .csv extension are taken as JSON when they start with '['.
+func readPayments(path string) ([]blockchain.Payment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return decodeJSONPayments(data)
+	case ".csv":
+		return decodeCSVPayments(data)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return decodeJSONPayments(data)
+	}
+	return decodeCSVPayments(data)
+}
+
+func decodeJSONPayments(data []byte) ([]blockchain.Payment, error) {
+	var payments []blockchain.Payment
+	if err := json.Unmarshal(data, &payments); err != nil {
+		return nil, fmt.Errorf("payments file: %w", err)
+	}
+	return payments, nil
+}
+
+// decodeCSVPayments skips a first line whose amount is not a number, taking
+// it for a header.
+func decodeCSVPayments(data []byte) ([]blockchain.Payment, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	var payments []blockchain.Payment
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			return payments, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("payments file: %w", err)
+		}
+
+		amount, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			if first {
+				continue
+			}
+			line, _ := r.FieldPos(1)
+			return nil, fmt.Errorf("payments file line %d: invalid amount %q", line, record[1])
+		}
+		payments = append(payments, blockchain.Payment{Address: strings.TrimSpace(record[0]), Amount: amount})
+	}
+}
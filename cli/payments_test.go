@@ -0,0 +1,106 @@
+package cli_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/cli"
+)
+
+const (
+	alice = "1HKkJHWSRwMfuLGjv9aDLJtWRCwdsCyGhZNABMAY7KJBZnJGrK"
+	bob   = "12asdNtW9cRwE3AHKD49j9CeccXfa8YGXsocAR8pcknP6qGbuWa"
+)
+
+func TestParsePayment(t *testing.T) {
+	tests := []struct {
+		in   string
+		want blockchain.Payment
+		ok   bool
+	}{
+		{alice + ":30", blockchain.Payment{Address: alice, Amount: 30}, true},
+		{" " + alice + " : 30 ", blockchain.Payment{Address: alice, Amount: 30}, true},
+		{alice + ":-5", blockchain.Payment{Address: alice, Amount: -5}, true},
+		{alice, blockchain.Payment{}, false},
+		{alice + ":", blockchain.Payment{}, false},
+		{alice + ":ten", blockchain.Payment{}, false},
+		{alice + ":1:2", blockchain.Payment{}, false},
+	}
+	for _, tt := range tests {
+		got, err := cli.ParsePayment(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%q: got %+v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestDecodeCSVPayments(t *testing.T) {
+	both := []blockchain.Payment{{Address: alice, Amount: 30}, {Address: bob, Amount: 12}}
+	tests := []struct {
+		name string
+		in   string
+		want []blockchain.Payment
+		ok   bool
+	}{
+		{"plain", alice + ",30\n" + bob + ",12\n", both, true},
+		{"header", "address,amount\n" + alice + ",30\n" + bob + ",12\n", both, true},
+		{"spaces and comments", "# payroll\n" + alice + ", 30\n\n" + bob + ",12 \n", both, true},
+		{"empty", "", nil, true},
+		{"bad amount after header", "address,amount\n" + alice + ",thirty\n", nil, false},
+		{"bad amount later", alice + ",30\n" + bob + ",twelve\n", nil, false},
+		{"missing amount", alice + "\n", nil, false},
+		{"extra field", alice + ",30,x\n", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cli.DecodeCSVPayments([]byte(tt.in))
+			if (err == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestReadPayments(t *testing.T) {
+	dir := t.TempDir()
+	both := []blockchain.Payment{{Address: alice, Amount: 30}, {Address: bob, Amount: 12}}
+	jsonData := `[{"address":"` + alice + `","amount":30},{"address":"` + bob + `","amount":12}]`
+	csvData := alice + ",30\n" + bob + ",12\n"
+
+	tests := []struct {
+		name string
+		file string
+		data string
+		ok   bool
+	}{
+		{"json extension", "pay.json", jsonData, true},
+		{"csv extension", "pay.CSV", csvData, true},
+		{"sniffed json", "pay.txt", "\n  " + jsonData, true},
+		{"sniffed csv", "payments", csvData, true},
+		// The extension wins over the content.
+		{"csv named json", "pay.json", csvData, false},
+		{"json named csv", "pay.csv", jsonData, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := cli.ReadPayments(path)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %+v, %v", got, err)
+			}
+			if tt.ok && !reflect.DeepEqual(got, both) {
+				t.Fatalf("got %+v", got)
+			}
+		})
+	}
+
+	if _, err := cli.ReadPayments(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("read a missing file")
+	}
+}
@@ -0,0 +1,145 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const subscriberBuffer = 256
+
+const (
+	BlockConnected    = "blockconnected"
+	BlockDisconnected = "blockdisconnected"
+	TxAccepted        = "txaccepted"
+	TxConfirmed       = "txconfirmed"
+)
+
+type (
+	// Event describes a change to the chain or the mempool. Addresses lists
+	// every address paying or paid by the block or transaction.
+	Event struct {
+		Type      string   `json:"type"`
+		Hash      string   `json:"hash,omitempty"`
+		TxID      string   `json:"txid,omitempty"`
+		Height    int      `json:"height"`
+		Addresses []string `json:"addresses,omitempty"`
+		Time      int64    `json:"time"`
+	}
+
+	// Filter selects the events a subscriber receives. Empty fields match
+	// everything.
+	Filter struct {
+		Types     []string
+		Addresses []string
+	}
+
+	Subscription struct {
+		C       <-chan Event
+		ch      chan Event
+		filter  Filter
+		bus     *Bus
+		dropped int
+	}
+
+	Bus struct {
+		mu   sync.RWMutex
+		subs map[*Subscription]struct{}
+	}
+)
+
+var DefaultBus = NewBus()
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) Match(e Event) bool {
+	if len(f.Types) > 0 && !contains(f.Types, e.Type) {
+		return false
+	}
+	if len(f.Addresses) == 0 {
+		return true
+	}
+	for _, addr := range e.Addresses {
+		if contains(f.Addresses, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bus) Subscribe(filter Filter) *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, filter: filter, bus: b}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Publish delivers e to every matching subscriber. It never blocks: a
+// subscriber whose buffer is full misses the event.
+func (b *Bus) Publish(e Event) {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+func (b *Bus) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subs)
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	}
+}
+
+// Dropped reports how many events were lost because the subscriber fell
+// behind.
+func (s *Subscription) Dropped() int {
+	s.bus.mu.RLock()
+	defer s.bus.mu.RUnlock()
+
+	return s.dropped
+}
+
+func Publish(e Event) {
+	DefaultBus.Publish(e)
+}
+
+func Subscribe(filter Filter) *Subscription {
+	return DefaultBus.Subscribe(filter)
+}
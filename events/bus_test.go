@@ -0,0 +1,50 @@
+package events_test
+
+import (
+	"testing"
+
+	"github.com/FG420/go-block/events"
+)
+
+func TestBusFiltersByAddress(t *testing.T) {
+	bus := events.NewBus()
+	all := bus.Subscribe(events.Filter{})
+	alice := bus.Subscribe(events.Filter{Addresses: []string{"alice"}})
+	blocks := bus.Subscribe(events.Filter{Types: []string{events.BlockConnected}})
+
+	bus.Publish(events.Event{Type: events.TxAccepted, TxID: "01", Addresses: []string{"bob"}})
+	bus.Publish(events.Event{Type: events.TxAccepted, TxID: "02", Addresses: []string{"bob", "alice"}})
+	bus.Publish(events.Event{Type: events.BlockConnected, Hash: "03"})
+
+	if got := len(all.C); got != 3 {
+		t.Errorf("unfiltered subscriber got %d events, want 3", got)
+	}
+	if got := len(alice.C); got != 1 {
+		t.Fatalf("address subscriber got %d events, want 1", got)
+	}
+	if e := <-alice.C; e.TxID != "02" {
+		t.Errorf("address subscriber got tx %s, want 02", e.TxID)
+	}
+	if got := len(blocks.C); got != 1 {
+		t.Errorf("type subscriber got %d events, want 1", got)
+	}
+
+	all.Close()
+	all.Close()
+	if bus.Subscribers() != 2 {
+		t.Errorf("got %d subscribers after close, want 2", bus.Subscribers())
+	}
+}
+
+func TestBusDropsForSlowSubscribers(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe(events.Filter{})
+
+	for i := 0; i < 300; i++ {
+		bus.Publish(events.Event{Type: events.TxAccepted})
+	}
+
+	if sub.Dropped() == 0 {
+		t.Error("expected events to be dropped for a full subscriber")
+	}
+}
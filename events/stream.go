@@ -0,0 +1,120 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const keepAliveInterval = 30 * time.Second
+
+func splitParams(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// FilterFromQuery builds a filter from repeatable or comma separated type and
+// address query parameters.
+func FilterFromQuery(r *http.Request) Filter {
+	query := r.URL.Query()
+	return Filter{
+		Types:     splitParams(query["type"]),
+		Addresses: splitParams(query["address"]),
+	}
+}
+
+// SSEHandler streams events from bus as Server-Sent Events.
+func SSEHandler(bus *Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		sub := bus.Subscribe(FilterFromQuery(r))
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// WebSocketHandler streams events from bus as JSON text frames.
+func WebSocketHandler(bus *Bus) http.Handler {
+	return websocket.Server{
+		// Events are read-only and carry no credentials, so any origin may
+		// subscribe.
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			sub := bus.Subscribe(FilterFromQuery(ws.Request()))
+			defer sub.Close()
+
+			// The client never sends anything we act on; a read returning
+			// means it went away.
+			closed := make(chan struct{})
+			go func() {
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+				close(closed)
+			}()
+
+			for {
+				select {
+				case <-closed:
+					return
+				case <-ws.Request().Context().Done():
+					return
+				case e, ok := <-sub.C:
+					if !ok {
+						return
+					}
+					if err := websocket.JSON.Send(ws, e); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+}
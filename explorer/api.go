@@ -0,0 +1,260 @@
+package explorer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/network"
+	"github.com/FG420/go-block/rpc"
+	"github.com/FG420/go-block/wallet"
+)
+
+type (
+	BlockSummary struct {
+		Hash      string `json:"hash"`
+		PrevHash  string `json:"previousblockhash"`
+		Height    int    `json:"height"`
+		Timestamp int64  `json:"time"`
+		TxCount   int    `json:"txcount"`
+	}
+
+	UTXOResult struct {
+		TxID  string `json:"txid"`
+		Vout  int    `json:"vout"`
+		Value int    `json:"value"`
+	}
+
+	BalanceResult struct {
+		Address string `json:"address"`
+		Balance int    `json:"balance"`
+		UTXOs   int    `json:"utxos"`
+	}
+
+	MempoolResult struct {
+		Size  int `json:"size"`
+		Bytes int `json:"bytes"`
+		Page
+	}
+)
+
+func summarize(b *blockchain.Block) BlockSummary {
+	return BlockSummary{
+		Hash:      hex.EncodeToString(b.Hash),
+		PrevHash:  hex.EncodeToString(b.PrevHash),
+		Height:    b.Height,
+		Timestamp: b.Timestamp,
+		TxCount:   len(b.Transactions),
+	}
+}
+
+// blocks lists the chain from the tip down.
+func (s *Server) blocks(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	best := s.chain.GetBestHeight()
+	start, end, err := bounds(page, limit, best+1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	items := []BlockSummary{}
+	iter := s.chain.Iterator()
+	for i := 0; i < end; i++ {
+		block := iter.Next()
+		if i >= start {
+			items = append(items, summarize(block))
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	writeJSON(w, http.StatusOK, Page{items, page, limit, best + 1})
+}
+
+func (s *Server) blockByHash(w http.ResponseWriter, r *http.Request) {
+	hash, err := hex.DecodeString(r.PathValue("hash"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "hash must be hex encoded")
+		return
+	}
+
+	block, err := s.chain.GetBlock(hash)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "block %x not found", hash)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rpc.NewBlockResult(block, s.chain.GetBestHeight()))
+}
+
+func (s *Server) blockByHeight(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || height < 0 {
+		writeError(w, http.StatusBadRequest, "height must be a non-negative integer")
+		return
+	}
+
+	best := s.chain.GetBestHeight()
+	if height > best {
+		writeError(w, http.StatusNotFound, "no block at height %d", height)
+		return
+	}
+
+	// Heights fall walking back from the tip, so the walk stops at the
+	// block or, if the tip moved, as soon as it is passed.
+	iter := s.chain.Iterator()
+	for {
+		block := iter.Next()
+		if block.Height == height {
+			writeJSON(w, http.StatusOK, rpc.NewBlockResult(block, best))
+			return
+		}
+		if block.Height < height || len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "no block at height %d", height)
+}
+
+func (s *Server) transaction(w http.ResponseWriter, r *http.Request) {
+	id, err := hex.DecodeString(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "id must be hex encoded")
+		return
+	}
+
+	if tx, ok := network.TxPool().Get(id); ok {
+		writeJSON(w, http.StatusOK, rpc.NewTxResult(&tx))
+		return
+	}
+
+	tx, block, err := s.chain.LocateTransaction(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "transaction %x not found", id)
+		return
+	}
+
+	res := rpc.NewTxResult(tx)
+	res.BlockHash = hex.EncodeToString(block.Hash)
+	res.Confirmations = s.chain.GetBestHeight() - block.Height + 1
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) unspent(w http.ResponseWriter, r *http.Request) (string, []blockchain.UTXO, bool) {
+	addr := r.PathValue("addr")
+	if !wallet.ValidateAddress(addr) {
+		writeError(w, http.StatusBadRequest, "invalid address %s", addr)
+		return "", nil, false
+	}
+
+	utxoSet := blockchain.UTXOSet{BlockChain: s.chain}
+	return addr, utxoSet.FindUnspent(wallet.AddressToHash(addr)), true
+}
+
+func (s *Server) addressUTXOs(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	_, utxos, ok := s.unspent(w, r)
+	if !ok {
+		return
+	}
+
+	start, end, err := bounds(page, limit, len(utxos))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	items := []UTXOResult{}
+	for _, utxo := range utxos[start:end] {
+		items = append(items, UTXOResult{
+			TxID:  hex.EncodeToString(utxo.TxID),
+			Vout:  utxo.Index,
+			Value: utxo.Output.Value,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, Page{items, page, limit, len(utxos)})
+}
+
+func (s *Server) addressBalance(w http.ResponseWriter, r *http.Request) {
+	addr, utxos, ok := s.unspent(w, r)
+	if !ok {
+		return
+	}
+
+	res := BalanceResult{Address: addr, UTXOs: len(utxos)}
+	for _, utxo := range utxos {
+		res.Balance += utxo.Output.Value
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) addressHistory(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	addr := r.PathValue("addr")
+	if !wallet.ValidateAddress(addr) {
+		writeError(w, http.StatusBadRequest, "invalid address %s", addr)
+		return
+	}
+
+	history := rpc.NewHistoryResults(s.chain.AddressHistory(wallet.AddressToHash(addr)), s.chain.GetBestHeight())
+	start, end, err := bounds(page, limit, len(history))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Page{history[start:end], page, limit, len(history)})
+}
+
+func (s *Server) mempool(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	pool := network.TxPool()
+	txs := pool.Transactions()
+	sort.Slice(txs, func(i, j int) bool {
+		return bytes.Compare(txs[i].ID, txs[j].ID) < 0
+	})
+	start, end, err := bounds(page, limit, len(txs))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	items := []rpc.TxResult{}
+	for i := start; i < end; i++ {
+		items = append(items, rpc.NewTxResult(&txs[i]))
+	}
+
+	writeJSON(w, http.StatusOK, MempoolResult{
+		Size:  pool.Count(),
+		Bytes: pool.Bytes(),
+		Page:  Page{items, page, limit, len(txs)},
+	})
+}
@@ -0,0 +1,11 @@
+package explorer
+
+const (
+	DefaultLimit = defaultLimit
+	MaxLimit     = maxLimit
+)
+
+var (
+	Pagination = pagination
+	Bounds     = bounds
+)
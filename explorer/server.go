@@ -0,0 +1,153 @@
+package explorer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/events"
+	"github.com/FG420/go-block/logging"
+)
+
+var apiLog = logging.Get(logging.API)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type (
+	// Page wraps a paginated listing. Page numbers start at 1.
+	Page struct {
+		Items any `json:"items"`
+		Page  int `json:"page"`
+		Limit int `json:"limit"`
+		Total int `json:"total"`
+	}
+
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+
+	Server struct {
+		chain *blockchain.BlockChain
+		http  *http.Server
+	}
+)
+
+func NewServer(chain *blockchain.BlockChain, addr string) *Server {
+	s := &Server{chain: chain}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /blocks", s.blocks)
+	mux.HandleFunc("GET /blocks/{hash}", s.blockByHash)
+	mux.HandleFunc("GET /blocks/height/{n}", s.blockByHeight)
+	mux.HandleFunc("GET /tx/{id}", s.transaction)
+	mux.HandleFunc("GET /address/{addr}/utxos", s.addressUTXOs)
+	mux.HandleFunc("GET /address/{addr}/balance", s.addressBalance)
+	mux.HandleFunc("GET /address/{addr}/history", s.addressHistory)
+	mux.HandleFunc("GET /mempool", s.mempool)
+	mux.Handle("GET /events", events.SSEHandler(events.DefaultBus))
+	mux.Handle("GET /events/ws", events.WebSocketHandler(events.DefaultBus))
+
+	// Event streams never finish on their own, so they are cancelled through
+	// the base context when the server shuts down.
+	base, cancel := context.WithCancel(context.Background())
+	s.http = &http.Server{
+		Addr:              addr,
+		Handler:           s.recoverer(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return base },
+	}
+	s.http.RegisterOnShutdown(cancel)
+
+	return s
+}
+
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	apiLog.Info("explorer listening", "addr", ln.Addr().String())
+
+	err = s.http.Serve(ln)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
+
+// recoverer turns the panics raised by handlers.HandleErr deep in the chain
+// code into a 500 instead of taking the node down.
+func (s *Server) recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				writeError(w, http.StatusInternalServerError, fmt.Sprint(rec))
+			}
+		}()
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, errorResponse{fmt.Sprintf(format, args...)})
+}
+
+// pagination reads the page and limit query parameters.
+func pagination(r *http.Request) (page, limit int, err error) {
+	page, limit = 1, defaultLimit
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("page must be a positive integer")
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	return page, limit, nil
+}
+
+// bounds returns the slice bounds of a page over total items. The first
+// page always exists, if empty; pages past the last one are an error.
+func bounds(page, limit, total int) (int, int, error) {
+	last := 1
+	if total > 0 {
+		last = (total-1)/limit + 1
+	}
+	if page < 1 || page > last {
+		return 0, 0, fmt.Errorf("page %d out of range, there are %d pages", page, last)
+	}
+
+	start := (page - 1) * limit
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,63 @@
+package explorer_test
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FG420/go-block/explorer"
+)
+
+func TestPagination(t *testing.T) {
+	tests := []struct {
+		query       string
+		page, limit int
+		ok          bool
+	}{
+		{"", 1, explorer.DefaultLimit, true},
+		{"?page=3", 3, explorer.DefaultLimit, true},
+		{"?page=2&limit=5", 2, 5, true},
+		{"?limit=1000", 1, explorer.MaxLimit, true},
+		{"?page=0", 0, 0, false},
+		{"?page=-1", 0, 0, false},
+		{"?page=two", 0, 0, false},
+		{"?limit=0", 0, 0, false},
+		{"?limit=", 1, explorer.DefaultLimit, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/blocks"+tt.query, nil)
+		page, limit, err := explorer.Pagination(r)
+		if (err == nil) != tt.ok || page != tt.page || limit != tt.limit {
+			t.Errorf("%q: got page %d limit %d err %v", tt.query, page, limit, err)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	tests := []struct {
+		page, limit, total int
+		start, end         int
+		ok                 bool
+	}{
+		{1, 10, 25, 0, 10, true},
+		{3, 10, 25, 20, 25, true},
+		{1, 10, 0, 0, 0, true},
+		{2, 5, 10, 5, 10, true},
+		{4, 10, 25, 0, 0, false},
+		{3, 5, 10, 0, 0, false},
+		{2, 10, 0, 0, 0, false},
+		{100, 10, 25, 0, 0, false},
+		{0, 10, 25, 0, 0, false},
+		{-1, 10, 25, 0, 0, false},
+		// (page-1)*limit would overflow to a negative start.
+		{math.MaxInt/10 + 2, 10, 25, 0, 0, false},
+		{math.MaxInt, explorer.MaxLimit, 25, 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, err := explorer.Bounds(tt.page, tt.limit, tt.total)
+		if start != tt.start || end != tt.end || (err == nil) != tt.ok {
+			t.Errorf("bounds(%d, %d, %d) = %d, %d, %v, want %d, %d",
+				tt.page, tt.limit, tt.total, start, end, err, tt.start, tt.end)
+		}
+	}
+}
@@ -1,18 +1,26 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
+	"github.com/FG420/go-block/logging"
 	"github.com/dgraph-io/badger"
 )
 
+var chainLog = logging.Get(logging.Chain)
+
 const (
 	DbPath      = "./tmp/blocks_%s"
 	GenesisData = "First Transaction from Genesis"
+	PidFile     = "node.pid"
 )
 
 func DbExist(path string) bool {
@@ -22,31 +30,175 @@ func DbExist(path string) bool {
 	return true
 }
 
-func Retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
-	lockPath := filepath.Join(dir, "LOCK")
-	if err := os.Remove(lockPath); err != nil {
-		return nil, fmt.Errorf(`Removing "LOCK": %s`, err)
+// LockedError is returned when another live process owns the database.
+type LockedError struct {
+	Dir string
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("database %s is in use by another instance (pid %d)", e.Dir, e.PID)
+}
+
+var (
+	openMu  sync.Mutex
+	openDBs = make(map[*badger.DB]string)
+)
+
+func pidPath(dir string) string {
+	return filepath.Join(dir, PidFile)
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// sameProgram reports whether pid runs an executable with the name of this
+// one, so a pid reused by another program after a crash doesn't keep a lock.
+// Without /proc any live process counts.
+func sameProgram(pid int) bool {
+	self, err := os.Executable()
+	if err != nil {
+		return true
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return true
+	}
+
+	// A binary rebuilt while the node runs shows up as deleted.
+	exe = strings.TrimSuffix(exe, " (deleted)")
+	return filepath.Base(exe) == filepath.Base(self)
+}
+
+// LockOwner returns the pid recorded in the lockfile of dir and whether that
+// process is still alive and running this program.
+func LockOwner(dir string) (int, bool) {
+	content, err := os.ReadFile(pidPath(dir))
+	if err != nil {
+		return 0, false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, false
+	}
+
+	return pid, processAlive(pid) && sameProgram(pid)
+}
+
+// AcquireLock claims dir for this process with a PID lockfile. A lockfile left
+// behind by a process that no longer exists, or whose pid now runs another
+// program, is taken over.
+func AcquireLock(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
 	}
 
-	retryOpts := originalOpts
-	retryOpts.Truncate = true
-	db, err := badger.Open(retryOpts)
-	return db, err
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(pidPath(dir), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return err
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		pid, alive := LockOwner(dir)
+		if alive && pid != os.Getpid() {
+			return &LockedError{dir, pid}
+		}
+		chainLog.Warn("removing stale lockfile", "pid", pid, "dir", dir)
+		if err := os.Remove(pidPath(dir)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("could not lock %s", dir)
+}
+
+func ReleaseLock(dir string) {
+	if pid, _ := LockOwner(dir); pid == os.Getpid() {
+		os.Remove(pidPath(dir))
+	}
 }
 
 func OpenDB(dir string, opts badger.Options) (*badger.DB, error) {
-	if db, err := badger.Open(opts); err != nil {
-		if strings.Contains(err.Error(), "LOCK") {
-			if db, err := Retry(dir, opts); err == nil {
-				log.Println("Database unlocked, value log truncated")
-				return db, nil
-			}
-			log.Println("Could not unlock db: ", err)
+	if err := AcquireLock(dir); err != nil {
+		return nil, err
+	}
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		ReleaseLock(dir)
+		if strings.Contains(err.Error(), "LOCK") || strings.Contains(err.Error(), "truncate") {
+			return nil, fmt.Errorf("%w\nIf no other node is using %s, run the repair command", err, dir)
 		}
 		return nil, err
-	} else {
-		return db, nil
 	}
+
+	openMu.Lock()
+	openDBs[db] = dir
+	openMu.Unlock()
+
+	return db, nil
+}
+
+func CloseDB(db *badger.DB) error {
+	openMu.Lock()
+	dir, ok := openDBs[db]
+	delete(openDBs, db)
+	openMu.Unlock()
+
+	err := db.Close()
+	if ok {
+		ReleaseLock(dir)
+	}
+	return err
+}
+
+// RepairDB recovers a database left locked by a crashed process: the stale
+// lockfiles are removed and the value log is truncated to its last
+// consistent entry. It refuses to touch a database owned by a live process
+// unless force is set, for a lockfile the owner check can't tell is stale.
+func RepairDB(dir string, opts badger.Options, force bool) error {
+	if force {
+		if pid, _ := LockOwner(dir); pid != 0 {
+			chainLog.Warn("removing lockfile", "pid", pid, "dir", dir)
+		}
+		if err := os.Remove(pidPath(dir)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := AcquireLock(dir); err != nil {
+		return err
+	}
+	defer ReleaseLock(dir)
+
+	lockPath := filepath.Join(dir, "LOCK")
+	if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf(`Removing "LOCK": %s`, err)
+	}
+
+	repairOpts := opts
+	repairOpts.Truncate = true
+	db, err := badger.Open(repairOpts)
+	if err != nil {
+		return err
+	}
+
+	return db.Close()
 }
 
 func HandleErr(err error) {
@@ -0,0 +1,146 @@
+package handlers_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/FG420/go-block/handlers"
+	"github.com/dgraph-io/badger"
+)
+
+// TestMain turns the test binary into a stand-in node when asked to, so the
+// tests have a live process running this program to hold a lock.
+func TestMain(m *testing.M) {
+	if os.Getenv("GO_BLOCK_LOCK_HOLDER") == "1" {
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// holder starts a live copy of this program and returns its pid.
+func holder(t *testing.T) int {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "GO_BLOCK_LOCK_HOLDER=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd.Process.Pid
+}
+
+// deadPid returns the pid of a process that has already exited.
+func deadPid(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func writePid(t *testing.T, dir string, pid int) {
+	if err := os.WriteFile(filepath.Join(dir, handlers.PidFile), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := handlers.AcquireLock(dir); err != nil {
+		t.Fatal(err)
+	}
+	if pid, alive := handlers.LockOwner(dir); pid != os.Getpid() || !alive {
+		t.Fatalf("owner %d alive %v", pid, alive)
+	}
+
+	// Taking a lock this process holds again is fine.
+	if err := handlers.AcquireLock(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	handlers.ReleaseLock(dir)
+	if _, err := os.Stat(filepath.Join(dir, handlers.PidFile)); !os.IsNotExist(err) {
+		t.Fatalf("lockfile left behind: %v", err)
+	}
+}
+
+func TestLockHeldByLiveNode(t *testing.T) {
+	dir := t.TempDir()
+	pid := holder(t)
+	writePid(t, dir, pid)
+
+	err := handlers.AcquireLock(dir)
+	var locked *handlers.LockedError
+	if !errors.As(err, &locked) || locked.PID != pid {
+		t.Fatalf("got %v", err)
+	}
+
+	// Releasing someone else's lock leaves it alone.
+	handlers.ReleaseLock(dir)
+	if owner, alive := handlers.LockOwner(dir); owner != pid || !alive {
+		t.Fatalf("owner %d alive %v", owner, alive)
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	tests := []struct {
+		name string
+		pid  int
+	}{
+		{"dead", deadPid(t)},
+		// The parent is the go tool, a live process running another program,
+		// as a pid reused after a crash would be.
+		{"reused", os.Getppid()},
+		{"garbage", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writePid(t, dir, tt.pid)
+
+			if _, alive := handlers.LockOwner(dir); alive {
+				t.Fatal("stale lock reported alive")
+			}
+			if err := handlers.AcquireLock(dir); err != nil {
+				t.Fatal(err)
+			}
+			if pid, _ := handlers.LockOwner(dir); pid != os.Getpid() {
+				t.Fatalf("owner %d", pid)
+			}
+			handlers.ReleaseLock(dir)
+		})
+	}
+}
+
+func TestRepairDB(t *testing.T) {
+	dir := t.TempDir()
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	writePid(t, dir, holder(t))
+
+	var locked *handlers.LockedError
+	if err := handlers.RepairDB(dir, opts, false); !errors.As(err, &locked) {
+		t.Fatalf("got %v", err)
+	}
+	if err := handlers.RepairDB(dir, opts, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, handlers.PidFile)); !os.IsNotExist(err) {
+		t.Fatalf("lockfile left behind: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const LogFile = "./tmp/node_%s.log"
+
+// Subsystems that have their own level.
+const (
+	Chain   = "chain"
+	Net     = "net"
+	Mempool = "mempool"
+	Miner   = "miner"
+	Wallet  = "wallet"
+	RPC     = "rpc"
+	API     = "api"
+)
+
+type (
+	Config struct {
+		// Levels is a comma separated list of a default level and
+		// subsystem=level overrides, e.g. "info,net=debug,miner=warn".
+		Levels string
+		JSON   bool
+		// File receives a copy of the log when set.
+		File string
+	}
+
+	// subsystemHandler tags records with the subsystem, filters them by the
+	// subsystem's level and hands them to the currently configured output.
+	subsystemHandler struct {
+		name  string
+		level *slog.LevelVar
+		attrs []slog.Attr
+		group string
+	}
+)
+
+var (
+	mu      sync.Mutex
+	levels  = make(map[string]*slog.LevelVar)
+	output  atomic.Pointer[slog.Handler]
+	logFile *os.File
+)
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	output.Store(&h)
+
+	for _, name := range subsystems {
+		levelVar(name)
+	}
+}
+
+var subsystems = []string{Chain, Net, Mempool, Miner, Wallet, RPC, API}
+
+func isSubsystem(name string) bool {
+	for _, sys := range subsystems {
+		if sys == name {
+			return true
+		}
+	}
+	return false
+}
+
+func levelVar(name string) *slog.LevelVar {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lv, ok := levels[name]
+	if !ok {
+		lv = new(slog.LevelVar)
+		levels[name] = lv
+	}
+	return lv
+}
+
+// Get returns the logger of a subsystem. Loggers can be created before Setup
+// runs and pick up its configuration.
+func Get(name string) *slog.Logger {
+	return slog.New(&subsystemHandler{name: name, level: levelVar(name)})
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := *output.Load()
+	out = out.WithAttrs([]slog.Attr{slog.String("sys", h.name)})
+	if len(h.attrs) > 0 {
+		out = out.WithAttrs(h.attrs)
+	}
+	if h.group != "" {
+		out = out.WithGroup(h.group)
+	}
+
+	return out.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		name = clone.group + "." + name
+	}
+	clone.group = name
+	return &clone
+}
+
+func parseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", value)
+	}
+	return level, nil
+}
+
+// ParseLevels reads a level spec into the default level and the per
+// subsystem overrides.
+func ParseLevels(spec string) (slog.Level, map[string]slog.Level, error) {
+	def := slog.LevelInfo
+	overrides := make(map[string]slog.Level)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			level, err := parseLevel(part)
+			if err != nil {
+				return 0, nil, err
+			}
+			def = level
+			continue
+		}
+
+		level, err := parseLevel(strings.TrimSpace(value))
+		if err != nil {
+			return 0, nil, err
+		}
+		overrides[strings.TrimSpace(name)] = level
+	}
+
+	return def, overrides, nil
+}
+
+// Setup configures levels and output for every subsystem logger.
+func Setup(cfg Config) error {
+	def, overrides, err := ParseLevels(cfg.Levels)
+	if err != nil {
+		return err
+	}
+	for name := range overrides {
+		if !isSubsystem(name) {
+			return fmt.Errorf("unknown log subsystem %q", name)
+		}
+	}
+
+	var w io.Writer = os.Stderr
+	if cfg.File != "" {
+		if err := os.MkdirAll(filepath.Dir(cfg.File), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w = io.MultiWriter(os.Stderr, file)
+
+		mu.Lock()
+		if logFile != nil {
+			logFile.Close()
+		}
+		logFile = file
+		mu.Unlock()
+	}
+
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	if cfg.JSON {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	output.Store(&h)
+
+	mu.Lock()
+	for name, lv := range levels {
+		level, ok := overrides[name]
+		if !ok {
+			level = def
+		}
+		lv.Set(level)
+	}
+	mu.Unlock()
+
+	return nil
+}
+
+// Close flushes and closes the log file.
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if logFile != nil {
+		var h slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		output.Store(&h)
+		logFile.Sync()
+		logFile.Close()
+		logFile = nil
+	}
+}
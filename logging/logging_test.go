@@ -0,0 +1,64 @@
+package logging_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/FG420/go-block/logging"
+)
+
+func TestParseLevels(t *testing.T) {
+	def, overrides, err := logging.ParseLevels("warn, net=debug,miner=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def != slog.LevelWarn {
+		t.Errorf("default level is %s, want WARN", def)
+	}
+	if overrides["net"] != slog.LevelDebug || overrides["miner"] != slog.LevelError {
+		t.Errorf("unexpected overrides %v", overrides)
+	}
+
+	if _, _, err := logging.ParseLevels("net=loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if err := logging.Setup(logging.Config{Levels: "chainz=debug"}); err == nil {
+		t.Error("expected an error for an unknown subsystem")
+	}
+}
+
+func TestSubsystemLevelsAndFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	netLog := logging.Get(logging.Net)
+	chainLog := logging.Get(logging.Chain)
+
+	err := logging.Setup(logging.Config{Levels: "warn,net=debug", JSON: true, File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	netLog.Debug("peer connected", "peer", "localhost:3001")
+	chainLog.Info("block added")
+	chainLog.Warn("reorg", "depth", 2)
+	logging.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), content)
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["sys"] != "net" || record["peer"] != "localhost:3001" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
@@ -7,9 +7,6 @@ import (
 )
 
 func main() {
-	defer os.Exit(0)
-	// Mian()
 	cmd := cli.CommandLine{}
-	cmd.Run()
-
+	os.Exit(cmd.Run())
 }
@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const namespace = "goblock"
+
+type (
+	collector interface {
+		name() string
+		write(w io.Writer)
+	}
+
+	desc struct {
+		fqName string
+		help   string
+		kind   string
+	}
+
+	Counter struct {
+		desc
+		bits atomic.Uint64
+	}
+
+	Gauge struct {
+		desc
+		bits atomic.Uint64
+	}
+
+	// CounterVec is a counter partitioned by the value of a single label.
+	CounterVec struct {
+		desc
+		label  string
+		mu     sync.Mutex
+		values map[string]float64
+	}
+
+	// GaugeFunc reports the value returned by fn at scrape time.
+	GaugeFunc struct {
+		desc
+		fn func() float64
+	}
+
+	Registry struct {
+		mu         sync.Mutex
+		collectors map[string]collector
+	}
+)
+
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+func fullName(name string) string {
+	return namespace + "_" + name
+}
+
+func (d *desc) name() string {
+	return d.fqName
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.fqName, d.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.fqName, d.kind)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
+
+func addFloat(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+// Register adds c to the registry, replacing a collector of the same name.
+func (r *Registry) Register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors[c.name()] = c
+}
+
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	buf.Flush()
+}
+
+// Handler serves the registry in the Prometheus text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{desc: desc{fullName(name), help, "counter"}}
+	DefaultRegistry.Register(c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	addFloat(&c.bits, delta)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.header(w)
+	fmt.Fprintf(w, "%s %s\n", c.fqName, formatValue(c.Value()))
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{fullName(name), help, "gauge"}}
+	DefaultRegistry.Register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(delta float64) {
+	addFloat(&g.bits, delta)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.fqName, formatValue(g.Value()))
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{fullName(name), help, "counter"},
+		label:  label,
+		values: make(map[string]float64),
+	}
+	DefaultRegistry.Register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(value string) {
+	c.mu.Lock()
+	c.values[value]++
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) Value(value string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[value]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]float64, len(keys))
+	for i, k := range keys {
+		values[i] = c.values[k]
+	}
+	c.mu.Unlock()
+
+	c.header(w)
+	for i, k := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", c.fqName, c.label, escapeLabel(k), formatValue(values[i]))
+	}
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{fullName(name), help, "gauge"}, fn: fn}
+	DefaultRegistry.Register(g)
+	return g
+}
+
+func (g *GaugeFunc) value() (v float64) {
+	defer func() {
+		if r := recover(); r != nil {
+			v = math.NaN()
+		}
+	}()
+	return g.fn()
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.fqName, formatValue(g.value()))
+}
+
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
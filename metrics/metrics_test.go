@@ -0,0 +1,39 @@
+package metrics_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/FG420/go-block/metrics"
+)
+
+func TestTextFormat(t *testing.T) {
+	counter := metrics.NewCounter("test_events_total", "Events seen by the test.")
+	vec := metrics.NewCounterVec("test_messages_total", "Messages seen by the test.", "command")
+	metrics.NewGaugeFunc("test_broken", "A gauge whose source panics.", func() float64 {
+		panic("unavailable")
+	})
+
+	counter.Add(2)
+	counter.Add(-1)
+	vec.Inc("tx")
+	vec.Inc("tx")
+	vec.Inc(`we"ird`)
+
+	var buf bytes.Buffer
+	metrics.DefaultRegistry.Write(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE goblock_test_events_total counter\n",
+		"goblock_test_events_total 2\n",
+		`goblock_test_messages_total{command="tx"} 2` + "\n",
+		`goblock_test_messages_total{command="we\"ird"} 1` + "\n",
+		"goblock_test_broken NaN\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
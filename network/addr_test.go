@@ -0,0 +1,125 @@
+package network_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/network"
+	"github.com/FG420/go-block/wallet"
+)
+
+// peer listens like a node and reports the command of every message it
+// receives.
+func peer(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	cmds := make(chan string, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			cmd := make([]byte, 12)
+			if _, err := conn.Read(cmd); err == nil {
+				cmds <- network.BytesToCmd(cmd)
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), cmds
+}
+
+func addrMessage(addrs ...string) []byte {
+	return append(network.CmdToBytes("addr"), network.GobEncode(network.Addr{AddrList: addrs})...)
+}
+
+func expectCmds(t *testing.T, name string, cmds <-chan string, want ...string) {
+	t.Helper()
+	for _, cmd := range want {
+		select {
+		case got := <-cmds:
+			if got != cmd {
+				t.Fatalf("%s got %q, want %q", name, got, cmd)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s got no %q", name, cmd)
+		}
+	}
+	select {
+	case got := <-cmds:
+		t.Fatalf("%s got unexpected %q", name, got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// Gossip about peers already known doesn't ask anyone for blocks again.
+func TestHandleAddrRequestsBlocksFromNewPeers(t *testing.T) {
+	known, knownCmds := peer(t)
+	fresh, freshCmds := peer(t)
+
+	defer network.SetAddrBook(network.SetAddrBook(network.NewAddrBook("addr-test")))
+	nodes := network.KnownNodes
+	network.KnownNodes = []string{known}
+	defer func() { network.KnownNodes = nodes }()
+
+	network.HandleAddr(addrMessage(known, fresh))
+	expectCmds(t, "new peer", freshCmds, "getblocks")
+	expectCmds(t, "known peer", knownCmds)
+
+	network.HandleAddr(addrMessage(known, fresh))
+	expectCmds(t, "new peer on repeat", freshCmds)
+	expectCmds(t, "known peer on repeat", knownCmds)
+}
+
+func TestRelayPeers(t *testing.T) {
+	defer network.SetAddrBook(network.SetAddrBook(nil))
+	seeds := filepath.Join(t.TempDir(), "seeds")
+	if err := os.WriteFile(seeds, []byte("seed:3000\nextra:3000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := network.RelayPeers(network.Config{NodeID: "relay-test", Connect: []string{"a:3000"}, AddNodes: []string{"b:3000"}, SeedsFile: seeds})
+	if err != nil || !reflect.DeepEqual(peers, []string{"a:3000"}) {
+		t.Fatalf("connect: got %v, %v", peers, err)
+	}
+
+	peers, err = network.RelayPeers(network.Config{NodeID: "relay-test", AddNodes: []string{"b:3000", "seed:3000"}, SeedsFile: seeds})
+	if err != nil || !reflect.DeepEqual(peers, []string{"b:3000", "seed:3000", "extra:3000"}) {
+		t.Fatalf("addnode: got %v, %v", peers, err)
+	}
+}
+
+// A transaction only counts as sent once some peer took it.
+func TestBroadcastTx(t *testing.T) {
+	defer network.SetAddrBook(network.SetAddrBook(network.NewAddrBook("relay-test")))
+	tx := blockchain.CoinbaseTx(string(wallet.MakeWallet().Address()), "")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := ln.Addr().String()
+	ln.Close()
+	live, cmds := peer(t)
+
+	if err := network.BroadcastTx(nil, tx); err == nil {
+		t.Error("sent to no peers")
+	}
+	if err := network.BroadcastTx([]string{dead}, tx); err == nil {
+		t.Error("sent to a dead peer")
+	}
+	if err := network.BroadcastTx([]string{dead, live}, tx); err != nil {
+		t.Fatal(err)
+	}
+	expectCmds(t, "live peer", cmds, "tx")
+}
@@ -0,0 +1,356 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	peersFile = "./tmp/peers_%s.json"
+	seedsFile = "./tmp/seeds_%s.txt"
+
+	maxOutbound    = 8
+	maxAddrPerMsg  = 1000
+	gossipInterval = 10 * time.Minute
+	freshAddrAge   = 3 * time.Hour
+	maxFailures    = 10
+	staleAddrAge   = 30 * 24 * time.Hour
+)
+
+type (
+	KnownAddress struct {
+		Addr        string
+		LastSeen    int64
+		LastAttempt int64
+		Successes   int
+		Failures    int
+	}
+
+	PeerInfo struct {
+		KnownAddress
+		Connected bool
+	}
+
+	AddrBook struct {
+		mu    sync.Mutex
+		path  string
+		Addrs map[string]*KnownAddress
+	}
+)
+
+func NewAddrBook(nodeId string) *AddrBook {
+	return &AddrBook{
+		path:  fmt.Sprintf(peersFile, nodeId),
+		Addrs: make(map[string]*KnownAddress),
+	}
+}
+
+func (ka *KnownAddress) isBad(now time.Time) bool {
+	if ka.Failures >= maxFailures && ka.Successes == 0 {
+		return true
+	}
+
+	lastSeen := time.Unix(ka.LastSeen, 0)
+	return ka.Failures > 3 && now.Sub(lastSeen) > staleAddrAge
+}
+
+func (ab *AddrBook) LoadFile() error {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	content, err := os.ReadFile(ab.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var addrs map[string]*KnownAddress
+	if err := json.Unmarshal(content, &addrs); err != nil {
+		return err
+	}
+	for addr, ka := range addrs {
+		ab.Addrs[addr] = ka
+	}
+
+	return nil
+}
+
+func (ab *AddrBook) SaveFile() error {
+	ab.mu.Lock()
+	data, err := json.MarshalIndent(ab.Addrs, "", "  ")
+	ab.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(ab.path, data, 0644)
+}
+
+func (ab *AddrBook) Len() int {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	return len(ab.Addrs)
+}
+
+func (ab *AddrBook) Add(addr string) bool {
+	if addr == "" || IsSelf(addr) {
+		return false
+	}
+
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	if _, ok := ab.Addrs[addr]; ok {
+		return false
+	}
+	ab.Addrs[addr] = &KnownAddress{Addr: addr}
+
+	return true
+}
+
+func (ab *AddrBook) Seen(addr string) {
+	ab.update(addr, func(ka *KnownAddress, now int64) {
+		ka.LastSeen = now
+	})
+}
+
+func (ab *AddrBook) Good(addr string) {
+	ab.update(addr, func(ka *KnownAddress, now int64) {
+		ka.LastSeen = now
+		ka.LastAttempt = now
+		ka.Successes++
+		ka.Failures = 0
+	})
+}
+
+func (ab *AddrBook) Failed(addr string) {
+	ab.update(addr, func(ka *KnownAddress, now int64) {
+		ka.LastAttempt = now
+		ka.Failures++
+	})
+
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	if ka, ok := ab.Addrs[addr]; ok && ka.isBad(time.Now()) {
+		delete(ab.Addrs, addr)
+	}
+}
+
+func (ab *AddrBook) update(addr string, fn func(ka *KnownAddress, now int64)) {
+	if addr == "" || IsSelf(addr) {
+		return
+	}
+
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	ka, ok := ab.Addrs[addr]
+	if !ok {
+		ka = &KnownAddress{Addr: addr}
+		ab.Addrs[addr] = ka
+	}
+	fn(ka, time.Now().Unix())
+}
+
+// Sample returns up to n random addresses that are not known to be bad.
+func (ab *AddrBook) Sample(n int) []string {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	now := time.Now()
+	var addrs []string
+	for addr, ka := range ab.Addrs {
+		if !ka.isBad(now) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	if len(addrs) > n {
+		addrs = addrs[:n]
+	}
+
+	return addrs
+}
+
+// Fresh returns up to n addresses we have heard from within maxAge.
+func (ab *AddrBook) Fresh(maxAge time.Duration, n int) []string {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge).Unix()
+	var addrs []string
+	for addr, ka := range ab.Addrs {
+		if ka.LastSeen >= cutoff {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	if len(addrs) > n {
+		addrs = addrs[:n]
+	}
+
+	return addrs
+}
+
+// Best returns up to n addresses ordered by how reliable they have been.
+func (ab *AddrBook) Best(n int) []string {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	now := time.Now()
+	var known []*KnownAddress
+	for _, ka := range ab.Addrs {
+		if !ka.isBad(now) {
+			known = append(known, ka)
+		}
+	}
+
+	sort.Slice(known, func(i, j int) bool {
+		if known[i].Failures != known[j].Failures {
+			return known[i].Failures < known[j].Failures
+		}
+		return known[i].LastSeen > known[j].LastSeen
+	})
+
+	var addrs []string
+	for i := 0; i < len(known) && i < n; i++ {
+		addrs = append(addrs, known[i].Addr)
+	}
+
+	return addrs
+}
+
+// Peers lists the connected peers followed by the rest of the address book.
+func Peers() []PeerInfo {
+	var peers []PeerInfo
+	connected := make(map[string]bool)
+	nodes := Nodes()
+
+	addrBook.mu.Lock()
+	defer addrBook.mu.Unlock()
+
+	for _, node := range nodes {
+		connected[node] = true
+		info := PeerInfo{KnownAddress: KnownAddress{Addr: node}, Connected: true}
+		if ka, ok := addrBook.Addrs[node]; ok {
+			info.KnownAddress = *ka
+		}
+		peers = append(peers, info)
+	}
+
+	for addr, ka := range addrBook.Addrs {
+		if !connected[addr] {
+			peers = append(peers, PeerInfo{KnownAddress: *ka})
+		}
+	}
+
+	return peers
+}
+
+// LoadSeeds reads one address per line from path, ignoring blank lines and
+// # comments. A missing file falls back to DefaultSeeds.
+func LoadSeeds(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return DefaultSeeds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var seeds []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			seeds = append(seeds, line)
+		}
+	}
+
+	return seeds, scanner.Err()
+}
+
+// RelayPeers returns the peers a command run without a node relays to,
+// chosen as StartServer chooses them: the Connect peers alone, or AddNodes,
+// the best of the address book and the seeds.
+func RelayPeers(cfg Config) ([]string, error) {
+	addrBook = NewAddrBook(cfg.NodeID)
+	if err := addrBook.LoadFile(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Connect) > 0 {
+		return cfg.Connect, nil
+	}
+
+	seedsPath := cfg.SeedsFile
+	if seedsPath == "" {
+		seedsPath = fmt.Sprintf(seedsFile, cfg.NodeID)
+	}
+	seeds, err := LoadSeeds(seedsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	seen := make(map[string]bool)
+	for _, group := range [][]string{cfg.AddNodes, addrBook.Best(maxOutbound), seeds} {
+		for _, peer := range group {
+			if peer = strings.TrimSpace(peer); peer != "" && !seen[peer] {
+				seen[peer] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+	return peers, nil
+}
+
+func GossipAddrs(ctx context.Context) {
+	ticker := time.NewTicker(gossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		nodes := Nodes()
+		rand.Shuffle(len(nodes), func(i, j int) {
+			nodes[i], nodes[j] = nodes[j], nodes[i]
+		})
+		if len(nodes) > 2 {
+			nodes = nodes[:2]
+		}
+
+		fresh := append(addrBook.Fresh(freshAddrAge, maxAddrPerMsg-1), nodeAddr)
+		for _, node := range nodes {
+			sendAddrList(node, fresh)
+		}
+
+		if err := addrBook.SaveFile(); err != nil {
+			netLog.Error("could not save peers", "err", err)
+		}
+	}
+}
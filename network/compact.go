@@ -0,0 +1,322 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/handlers"
+)
+
+const (
+	shortIDLength     = 6
+	maxPendingCompact = 32
+)
+
+type (
+	PrefilledTx struct {
+		Index int
+		Tx    []byte
+	}
+
+	// CompactBlock carries a block header together with salted short ids of
+	// its transactions. Transactions the receiver can't be expected to have,
+	// like the coinbase, are sent in full.
+	CompactBlock struct {
+		AddrFrom  string
+		Hash      []byte
+		PrevHash  []byte
+		Nonce     int
+		Timestamp int64
+		Height    int
+		Salt      uint64
+		ShortIDs  [][]byte
+		Prefilled []PrefilledTx
+	}
+
+	GetBlockTxn struct {
+		AddrFrom string
+		Hash     []byte
+		Indexes  []int
+	}
+
+	BlockTxn struct {
+		AddrFrom string
+		Hash     []byte
+		Txs      [][]byte
+	}
+
+	partialBlock struct {
+		compact CompactBlock
+		txs     []*blockchain.Transaction
+		missing []int
+		added   time.Time
+	}
+)
+
+var (
+	pendingCompactMu sync.Mutex
+	pendingCompact   = make(map[string]*partialBlock)
+)
+
+func shortID(salt uint64, txID []byte) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], salt)
+	hash := sha256.Sum256(append(buf[:], txID...))
+
+	return hash[:shortIDLength]
+}
+
+func NewCompactBlock(b *blockchain.Block) CompactBlock {
+	var salt [8]byte
+	_, err := rand.Read(salt[:])
+	handlers.HandleErr(err)
+
+	cb := CompactBlock{
+		AddrFrom:  nodeAddr,
+		Hash:      b.Hash,
+		PrevHash:  b.PrevHash,
+		Nonce:     b.Nonce,
+		Timestamp: b.Timestamp,
+		Height:    b.Height,
+		Salt:      binary.BigEndian.Uint64(salt[:]),
+	}
+
+	for i, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			cb.Prefilled = append(cb.Prefilled, PrefilledTx{i, tx.Serialize()})
+			cb.ShortIDs = append(cb.ShortIDs, nil)
+			continue
+		}
+		cb.ShortIDs = append(cb.ShortIDs, shortID(cb.Salt, tx.ID))
+	}
+
+	return cb
+}
+
+// reconstruct fills the block's transactions from the prefilled ones and the
+// mempool and records the indexes that are still missing.
+func (pb *partialBlock) reconstruct() {
+	pb.txs = make([]*blockchain.Transaction, len(pb.compact.ShortIDs))
+	for _, pre := range pb.compact.Prefilled {
+		if pre.Index < 0 || pre.Index >= len(pb.txs) {
+			continue
+		}
+		tx := blockchain.DeserializeTransaction(pre.Tx)
+		pb.txs[pre.Index] = &tx
+	}
+
+	candidates := make(map[string]*blockchain.Transaction)
+	collisions := make(map[string]bool)
+	for _, tx := range memoryPool.Transactions() {
+		tx := tx
+		key := hex.EncodeToString(shortID(pb.compact.Salt, tx.ID))
+		if _, ok := candidates[key]; ok {
+			collisions[key] = true
+		}
+		candidates[key] = &tx
+	}
+
+	pb.missing = nil
+	for i, id := range pb.compact.ShortIDs {
+		if pb.txs[i] != nil {
+			continue
+		}
+		key := hex.EncodeToString(id)
+		if tx, ok := candidates[key]; ok && !collisions[key] {
+			pb.txs[i] = tx
+		} else {
+			pb.missing = append(pb.missing, i)
+		}
+	}
+}
+
+func (pb *partialBlock) block() *blockchain.Block {
+	return &blockchain.Block{
+		Hash:         pb.compact.Hash,
+		Transactions: pb.txs,
+		PrevHash:     pb.compact.PrevHash,
+		Nonce:        pb.compact.Nonce,
+		Timestamp:    pb.compact.Timestamp,
+		Height:       pb.compact.Height,
+	}
+}
+
+func addPendingCompact(pb *partialBlock) bool {
+	pendingCompactMu.Lock()
+	defer pendingCompactMu.Unlock()
+
+	now := time.Now()
+	for hash, pending := range pendingCompact {
+		if now.Sub(pending.added) > pendingRequestTTL {
+			delete(pendingCompact, hash)
+		}
+	}
+	if len(pendingCompact) >= maxPendingCompact {
+		return false
+	}
+
+	pendingCompact[hex.EncodeToString(pb.compact.Hash)] = pb
+	return true
+}
+
+func takePendingCompact(hash []byte) *partialBlock {
+	pendingCompactMu.Lock()
+	defer pendingCompactMu.Unlock()
+
+	key := hex.EncodeToString(hash)
+	pb := pendingCompact[key]
+	delete(pendingCompact, key)
+
+	return pb
+}
+
+func HandleCompactBlock(req []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload CompactBlock
+
+	buff.Write(req[cmdLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	handlers.HandleErr(err)
+
+	markKnown(payload.AddrFrom, payload.Hash)
+	requests.done("block" + hex.EncodeToString(payload.Hash))
+
+	if chain.HasBlock(payload.Hash) {
+		return
+	}
+	if len(payload.PrevHash) > 0 && !chain.HasBlock(payload.PrevHash) {
+		SendGetData(payload.AddrFrom, "block", payload.Hash)
+		return
+	}
+
+	pb := &partialBlock{compact: payload, added: time.Now()}
+	pb.reconstruct()
+
+	if len(pb.missing) == 0 {
+		connectCompactBlock(chain, pb, payload.AddrFrom)
+		return
+	}
+
+	netLog.Debug("compact block incomplete", "hash", hex.EncodeToString(payload.Hash), "missing", len(pb.missing), "txs", len(pb.txs))
+	if !addPendingCompact(pb) {
+		SendGetData(payload.AddrFrom, "block", payload.Hash)
+		return
+	}
+	SendGetBlockTxn(payload.AddrFrom, payload.Hash, pb.missing)
+}
+
+func HandleGetBlockTxn(req []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload GetBlockTxn
+
+	buff.Write(req[cmdLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	handlers.HandleErr(err)
+
+	block, err := chain.GetBlock(payload.Hash)
+	if err != nil {
+		return
+	}
+
+	var txs [][]byte
+	for _, i := range payload.Indexes {
+		if i < 0 || i >= len(block.Transactions) {
+			SendBlock(payload.AddrFrom, block)
+			return
+		}
+		txs = append(txs, block.Transactions[i].Serialize())
+	}
+
+	SendBlockTxn(payload.AddrFrom, payload.Hash, txs)
+}
+
+func HandleBlockTxn(req []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload BlockTxn
+
+	buff.Write(req[cmdLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	handlers.HandleErr(err)
+
+	pb := takePendingCompact(payload.Hash)
+	if pb == nil {
+		return
+	}
+
+	if len(payload.Txs) != len(pb.missing) {
+		SendGetData(payload.AddrFrom, "block", payload.Hash)
+		return
+	}
+
+	for i, data := range payload.Txs {
+		tx := blockchain.DeserializeTransaction(data)
+		index := pb.missing[i]
+		if !bytes.Equal(shortID(pb.compact.Salt, tx.ID), pb.compact.ShortIDs[index]) {
+			netLog.Warn("compact block reconstruction failed, requesting full block", "hash", hex.EncodeToString(payload.Hash))
+			SendGetData(payload.AddrFrom, "block", payload.Hash)
+			return
+		}
+		pb.txs[index] = &tx
+	}
+	pb.missing = nil
+
+	connectCompactBlock(chain, pb, payload.AddrFrom)
+}
+
+// matchesHeader reports whether the transactions of a reconstructed block
+// hash to the proof of work of its header. A short id colliding with another
+// mempool transaction, or a bad blocktxn, makes it fail.
+func matchesHeader(block *blockchain.Block) bool {
+	pow := blockchain.NewProof(block)
+	hash := sha256.Sum256(pow.InitData(block.Nonce))
+
+	return pow.Validate() && bytes.Equal(hash[:], block.Hash)
+}
+
+func connectCompactBlock(chain *blockchain.BlockChain, pb *partialBlock, from string) {
+	block := pb.block()
+	if !matchesHeader(block) {
+		invalidMessages.Inc("compact")
+		netLog.Warn("reconstructed compact block does not match its header, requesting full block", "hash", hex.EncodeToString(block.Hash), "peer", from)
+		SendGetData(from, "block", block.Hash)
+		return
+	}
+	netLog.Debug("reconstructed compact block", "hash", hex.EncodeToString(block.Hash))
+
+	ProcessBlock(chain, block, from, len(block.Serialize()))
+	if chain.HasBlock(block.Hash) {
+		reindexUTXO(chain)
+		Announce("block", chain.LastHash)
+	}
+}
+
+func SendCompactBlock(addr string, b *blockchain.Block) {
+	payload := GobEncode(NewCompactBlock(b))
+	req := append(CmdToBytes("cmpctblock"), payload...)
+
+	SendData(addr, req)
+}
+
+func SendGetBlockTxn(addr string, hash []byte, indexes []int) {
+	payload := GobEncode(GetBlockTxn{nodeAddr, hash, indexes})
+	req := append(CmdToBytes("getblocktxn"), payload...)
+
+	SendData(addr, req)
+}
+
+func SendBlockTxn(addr string, hash []byte, txs [][]byte) {
+	payload := GobEncode(BlockTxn{nodeAddr, hash, txs})
+	req := append(CmdToBytes("blocktxn"), payload...)
+
+	SendData(addr, req)
+}
@@ -0,0 +1,101 @@
+package network_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/network"
+	"github.com/FG420/go-block/wallet"
+)
+
+// spend makes a transaction with a unique ID; the mempool doesn't check
+// signatures.
+func spend(n byte) blockchain.Transaction {
+	tx := blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{{ID: []byte{n}, Out: 0}},
+		Outputs: []blockchain.TxOutput{{Value: int(n), PubKeyHash: []byte{n}}},
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func coinbase() *blockchain.Transaction {
+	return blockchain.CoinbaseTx(string(wallet.MakeWallet().Address()), "reward")
+}
+
+func TestShortID(t *testing.T) {
+	tx := spend(1)
+	id := network.ShortID(7, tx.ID)
+	if len(id) != 6 {
+		t.Fatalf("short id has %d bytes", len(id))
+	}
+	if !bytes.Equal(id, network.ShortID(7, tx.ID)) {
+		t.Error("short id is not deterministic")
+	}
+	if bytes.Equal(id, network.ShortID(8, tx.ID)) {
+		t.Error("short id ignores the salt")
+	}
+}
+
+func TestCompactBlockReconstruction(t *testing.T) {
+	known, unknown := spend(10), spend(11)
+	network.TxPool().Add(known)
+	defer network.TxPool().Remove([]*blockchain.Transaction{&known})
+
+	cbTx := coinbase()
+	block := blockchain.CreateBlock([]*blockchain.Transaction{cbTx, &known, &unknown}, []byte{1}, 1)
+	cb := network.NewCompactBlock(block)
+
+	rebuilt, missing := network.ReconstructCompact(cb)
+	if len(missing) != 1 || missing[0] != 2 {
+		t.Fatalf("missing %v, want [2]", missing)
+	}
+	if !bytes.Equal(rebuilt.Transactions[0].ID, cbTx.ID) || !bytes.Equal(rebuilt.Transactions[1].ID, known.ID) {
+		t.Fatal("prefilled or mempool transaction not placed")
+	}
+
+	network.TxPool().Add(unknown)
+	defer network.TxPool().Remove([]*blockchain.Transaction{&unknown})
+	rebuilt, missing = network.ReconstructCompact(cb)
+	if len(missing) != 0 {
+		t.Fatalf("missing %v with every transaction in the mempool", missing)
+	}
+	if !bytes.Equal(rebuilt.HashTransactions(), block.HashTransactions()) || !blockchain.NewProof(rebuilt).Validate() {
+		t.Fatal("reconstructed block does not match")
+	}
+}
+
+// A block whose rebuilt transactions don't hash to the header is not stored;
+// the full block is requested from the sender instead.
+func TestCompactBlockFallback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	tx, other := spend(20), spend(21)
+	cbTx := coinbase()
+	block := blockchain.CreateBlock([]*blockchain.Transaction{cbTx, &tx}, []byte{1}, 1)
+
+	// A nil chain panics if the block gets past the header check.
+	go network.ConnectCompactBlock(nil, network.NewCompactBlock(block), []*blockchain.Transaction{cbTx, &other}, ln.Addr().String())
+
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal("full block was not requested: ", err)
+	}
+	defer conn.Close()
+
+	cmd := make([]byte, 12)
+	if _, err := conn.Read(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if got := network.BytesToCmd(cmd); got != "getdata" {
+		t.Fatalf("sent %q, want getdata", got)
+	}
+}
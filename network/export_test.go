@@ -0,0 +1,124 @@
+package network
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+)
+
+const (
+	MaxOrphanBlocks = maxOrphanBlocks
+	MaxOrphanBytes  = maxOrphanBytes
+	OrphanTTL       = orphanTTL
+)
+
+func (op *OrphanPool) SetClock(now func() time.Time) {
+	op.now = now
+}
+
+func OrphanBlocks(orphans []*orphanBlock) []*blockchain.Block {
+	var blocks []*blockchain.Block
+	for _, orphan := range orphans {
+		blocks = append(blocks, orphan.block)
+	}
+	return blocks
+}
+
+var ShortID = shortID
+
+// ReconstructCompact rebuilds cb from the mempool and returns the block and
+// the indexes of the transactions still missing.
+func ReconstructCompact(cb CompactBlock) (*blockchain.Block, []int) {
+	pb := &partialBlock{compact: cb}
+	pb.reconstruct()
+	return pb.block(), pb.missing
+}
+
+func ConnectCompactBlock(chain *blockchain.BlockChain, cb CompactBlock, txs []*blockchain.Transaction, from string) {
+	connectCompactBlock(chain, &partialBlock{compact: cb, txs: txs}, from)
+}
+
+func NewConnLimiter() *connLimiter {
+	return newConnLimiter()
+}
+
+func (cl *connLimiter) Acquire(conn net.Conn) bool {
+	return cl.acquire(conn)
+}
+
+func (cl *connLimiter) Release(conn net.Conn) {
+	cl.release(conn)
+}
+
+func (cl *connLimiter) AllowMessage(sender string, size int) bool {
+	return cl.allowMessage(sender, size)
+}
+
+func (cl *connLimiter) Tracked() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	return len(cl.peers)
+}
+
+func (cl *connLimiter) Reader(r io.Reader) *budgetReader {
+	return cl.reader(r)
+}
+
+func (br *budgetReader) Release() {
+	br.release()
+}
+
+var ErrBufferFull = errBufferFull
+
+func NewTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	b := newBucket(rate, burst, now)
+	return &b
+}
+
+func (b *tokenBucket) Take(n float64, now time.Time) bool {
+	return b.take(n, now)
+}
+
+func NewRequestTracker() *requestTracker {
+	return newRequestTracker()
+}
+
+func (rt *requestTracker) Add(key string) bool {
+	return rt.add(key)
+}
+
+func (rt *requestTracker) Done(key string) {
+	rt.done(key)
+}
+
+var (
+	MessageSender      = messageSender
+	NextBlockInTransit = nextBlockInTransit
+	ReceivedInTransit  = receivedInTransit
+)
+
+func SetBlocksInTransit(hashes [][]byte, peer string) {
+	transitMu.Lock()
+	defer transitMu.Unlock()
+
+	blocksInTransit, transitPeer = hashes, peer
+}
+
+// ExpireRequest forgets the outstanding request for key, as if it had timed
+// out.
+func ExpireRequest(key string) {
+	requests.done(key)
+}
+
+// SetAddrBook installs ab as the node's address book and returns the one it
+// replaced.
+func SetAddrBook(ab *AddrBook) *AddrBook {
+	old := addrBook
+	addrBook = ab
+	return old
+}
+
+var StopServices = stopServices
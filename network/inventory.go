@@ -0,0 +1,76 @@
+package network
+
+import (
+	"encoding/hex"
+	"sync"
+)
+
+const maxKnownInventory = 5000
+
+type inventorySet struct {
+	items map[string]struct{}
+	order []string
+}
+
+var (
+	knownInvMu sync.Mutex
+	knownInv   = make(map[string]*inventorySet)
+)
+
+// markKnown records that peer already has the item, so it is not announced
+// back to it. The oldest entries are forgotten once the set is full.
+func markKnown(peer string, id []byte) {
+	if peer == "" {
+		return
+	}
+
+	knownInvMu.Lock()
+	defer knownInvMu.Unlock()
+
+	set, ok := knownInv[peer]
+	if !ok {
+		set = &inventorySet{items: make(map[string]struct{})}
+		knownInv[peer] = set
+	}
+
+	key := hex.EncodeToString(id)
+	if _, ok := set.items[key]; ok {
+		return
+	}
+	if len(set.order) >= maxKnownInventory {
+		delete(set.items, set.order[0])
+		set.order = set.order[1:]
+	}
+	set.items[key] = struct{}{}
+	set.order = append(set.order, key)
+}
+
+func isKnown(peer string, id []byte) bool {
+	knownInvMu.Lock()
+	defer knownInvMu.Unlock()
+
+	set, ok := knownInv[peer]
+	if !ok {
+		return false
+	}
+	_, ok = set.items[hex.EncodeToString(id)]
+	return ok
+}
+
+func forgetPeer(peer string) {
+	knownInvMu.Lock()
+	defer knownInvMu.Unlock()
+
+	delete(knownInv, peer)
+}
+
+// Announce sends an inventory for id to every peer not yet known to have it.
+func Announce(kind string, id []byte) {
+	for _, node := range Nodes() {
+		if isKnown(node, id) {
+			continue
+		}
+		markKnown(node, id)
+		SendInv(node, kind, [][]byte{id})
+	}
+}
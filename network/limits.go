@@ -0,0 +1,279 @@
+package network
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	MaxInbound      = 125
+	MaxConnsPerPeer = 16
+	MaxMessageSize  = 32 << 20
+
+	// MaxTrackedPeers caps the senders with a rate limit state and
+	// MaxBufferedBytes the message bytes read into memory at once.
+	MaxTrackedPeers  = 4096
+	MaxBufferedBytes = 256 << 20
+
+	readTimeout = 30 * time.Second
+
+	// Per peer message rate and bandwidth, refilled continuously.
+	msgRate        = 50
+	msgBurst       = 200
+	bandwidthRate  = 4 << 20
+	bandwidthBurst = 64 << 20
+
+	maxOrphanBlocks = 100
+	maxOrphanBytes  = 32 << 20
+	orphanTTL       = 20 * time.Minute
+
+	maxInvItems        = 50000
+	maxPendingRequests = 5000
+	pendingRequestTTL  = 2 * time.Minute
+	blockRetryInterval = 30 * time.Second
+	idlePeerTTL        = 10 * time.Minute
+)
+
+type (
+	tokenBucket struct {
+		tokens float64
+		rate   float64
+		burst  float64
+		last   time.Time
+	}
+
+	peerLimits struct {
+		msgs     tokenBucket
+		bytes    tokenBucket
+		lastSeen time.Time
+	}
+
+	// connLimiter caps open connections per remote host and charges messages
+	// to the sending peer. Nodes on one machine share a host, so loopback
+	// connections only count towards MaxInbound.
+	connLimiter struct {
+		mu       sync.Mutex
+		inbound  int
+		buffered int
+		hosts    map[string]int
+		peers    map[string]*peerLimits
+	}
+
+	// budgetReader reserves every byte read from the limiter's buffer budget
+	// until release.
+	budgetReader struct {
+		r        io.Reader
+		cl       *connLimiter
+		reserved int
+	}
+
+	// requestTracker remembers outstanding getdata requests so the same item
+	// isn't requested repeatedly and the set can't grow without bound.
+	requestTracker struct {
+		mu    sync.Mutex
+		items map[string]time.Time
+	}
+)
+
+var (
+	limiter  = newConnLimiter()
+	requests = newRequestTracker()
+
+	errBufferFull = errors.New("message buffer budget exhausted")
+)
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{hosts: make(map[string]int), peers: make(map[string]*peerLimits)}
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{items: make(map[string]time.Time)}
+}
+
+func newBucket(rate, burst float64, now time.Time) tokenBucket {
+	return tokenBucket{tokens: burst, rate: rate, burst: burst, last: now}
+}
+
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+func peerHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// messageSender names the peer a message is charged to: its TLS identity,
+// or else its host. Local nodes share the loopback host and have nothing
+// else that can't be forged, so a plain loopback connection is charged on
+// its own and held only by MaxInbound and MaxBufferedBytes.
+func messageSender(conn net.Conn) string {
+	if id := PeerIdentity(conn); id != "" {
+		return id
+	}
+
+	host := peerHost(conn)
+	if isLoopback(host) {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// acquire admits a new inbound connection if neither the global nor the per
+// host connection cap has been reached.
+func (cl *connLimiter) acquire(conn net.Conn) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.inbound >= MaxInbound {
+		return false
+	}
+
+	host := peerHost(conn)
+	if !isLoopback(host) {
+		if cl.hosts[host] >= MaxConnsPerPeer {
+			return false
+		}
+		cl.hosts[host]++
+	}
+
+	cl.inbound++
+	return true
+}
+
+func (cl *connLimiter) release(conn net.Conn) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.inbound--
+	host := peerHost(conn)
+	if isLoopback(host) && PeerIdentity(conn) == "" {
+		delete(cl.peers, conn.RemoteAddr().String())
+	}
+	if cl.hosts[host] > 1 {
+		cl.hosts[host]--
+	} else {
+		delete(cl.hosts, host)
+	}
+}
+
+// allowMessage charges a received message against the sender's message rate
+// and bandwidth allowance.
+func (cl *connLimiter) allowMessage(sender string, size int) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	now := time.Now()
+	peer, ok := cl.peers[sender]
+	if !ok {
+		cl.prune(now)
+		peer = &peerLimits{
+			msgs:  newBucket(msgRate, msgBurst, now),
+			bytes: newBucket(bandwidthRate, bandwidthBurst, now),
+		}
+		cl.peers[sender] = peer
+	}
+
+	peer.lastSeen = now
+	return peer.msgs.take(1, now) && peer.bytes.take(float64(size), now)
+}
+
+// prune forgets idle senders and, if MaxTrackedPeers are still tracked, the
+// one seen longest ago.
+func (cl *connLimiter) prune(now time.Time) {
+	var oldest string
+	for sender, peer := range cl.peers {
+		if now.Sub(peer.lastSeen) > idlePeerTTL {
+			delete(cl.peers, sender)
+		} else if oldest == "" || peer.lastSeen.Before(cl.peers[oldest].lastSeen) {
+			oldest = sender
+		}
+	}
+	if len(cl.peers) >= MaxTrackedPeers {
+		delete(cl.peers, oldest)
+	}
+}
+
+// reader reads a message from r within the limiter's buffer budget.
+func (cl *connLimiter) reader(r io.Reader) *budgetReader {
+	return &budgetReader{r: r, cl: cl}
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	if n == 0 {
+		return n, err
+	}
+
+	br.cl.mu.Lock()
+	defer br.cl.mu.Unlock()
+
+	if br.cl.buffered+n > MaxBufferedBytes {
+		return 0, errBufferFull
+	}
+	br.cl.buffered += n
+	br.reserved += n
+	return n, err
+}
+
+// release returns the bytes read to the budget once the message is handled.
+func (br *budgetReader) release() {
+	br.cl.mu.Lock()
+	defer br.cl.mu.Unlock()
+
+	br.cl.buffered -= br.reserved
+	br.reserved = 0
+}
+
+// add marks key as requested and reports whether a new request should be sent.
+func (rt *requestTracker) add(key string) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	now := time.Now()
+	if at, ok := rt.items[key]; ok && now.Sub(at) < pendingRequestTTL {
+		return false
+	}
+
+	if len(rt.items) >= maxPendingRequests {
+		for k, at := range rt.items {
+			if now.Sub(at) >= pendingRequestTTL {
+				delete(rt.items, k)
+			}
+		}
+		if len(rt.items) >= maxPendingRequests {
+			return false
+		}
+	}
+
+	rt.items[key] = now
+	return true
+}
+
+func (rt *requestTracker) done(key string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	delete(rt.items, key)
+}
@@ -0,0 +1,265 @@
+package network_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FG420/go-block/network"
+)
+
+// remoteConn is a connection that appears to come from addr.
+type remoteConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func from(ip string, port int) net.Conn {
+	return remoteConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: port}}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := network.NewTokenBucket(10, 20, now)
+
+	for i := 0; i < 20; i++ {
+		if !b.Take(1, now) {
+			t.Fatalf("burst exhausted after %d tokens", i)
+		}
+	}
+	if b.Take(1, now) {
+		t.Fatal("took more than the burst")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !b.Take(5, now) || b.Take(1, now) {
+		t.Fatal("bucket did not refill at its rate")
+	}
+
+	now = now.Add(time.Hour)
+	if !b.Take(20, now) || b.Take(1, now) {
+		t.Fatal("bucket refilled past its burst")
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	cl := network.NewConnLimiter()
+
+	var admitted []net.Conn
+	for port := 1; port <= 2*network.MaxConnsPerPeer; port++ {
+		if conn := from("10.0.0.1", port); cl.Acquire(conn) {
+			admitted = append(admitted, conn)
+		}
+	}
+	if len(admitted) != network.MaxConnsPerPeer {
+		t.Fatalf("admitted %d connections from one host, want %d", len(admitted), network.MaxConnsPerPeer)
+	}
+	if !cl.Acquire(from("10.0.0.2", 1)) {
+		t.Fatal("a full host blocked another one")
+	}
+	cl.Release(admitted[0])
+	if !cl.Acquire(from("10.0.0.1", 1000)) {
+		t.Fatal("released slot was not reused")
+	}
+
+	// Local nodes share the loopback address and only count towards the
+	// global cap.
+	local := 0
+	for port := 1; port <= network.MaxInbound; port++ {
+		if cl.Acquire(from("127.0.0.1", port)) {
+			local++
+		}
+	}
+	if want := network.MaxInbound - network.MaxConnsPerPeer - 1; local != want {
+		t.Fatalf("admitted %d loopback connections, want %d", local, want)
+	}
+}
+
+// A local flood of connections and messages is held to the caps while other
+// peers keep their allowance.
+func TestLocalFlood(t *testing.T) {
+	cl := network.NewConnLimiter()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var mu sync.Mutex
+	admitted, refused := 0, 0
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			if cl.Acquire(conn) {
+				admitted++
+			} else {
+				refused++
+				conn.Close()
+			}
+			mu.Unlock()
+		}
+	}()
+
+	const flood = network.MaxInbound + 75
+	var conns []net.Conn
+	for i := 0; i < flood; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := admitted+refused == flood
+		a, r := admitted, refused
+		mu.Unlock()
+		if done {
+			if a != network.MaxInbound || r != flood-network.MaxInbound {
+				t.Fatalf("admitted %d and refused %d connections", a, r)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d connections handled", a+r, flood)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	allowed := 0
+	for i := 0; i < 10000; i++ {
+		if cl.AllowMessage("localhost:3001", 100) {
+			allowed++
+		}
+	}
+	if allowed < 200 || allowed > 210 {
+		t.Fatalf("allowed %d of a flood of 10000 messages", allowed)
+	}
+	if !cl.AllowMessage("localhost:3002", 100) {
+		t.Fatal("another local node was rate limited by the flood")
+	}
+	if cl.AllowMessage("localhost:3003", network.MaxMessageSize*3) {
+		t.Fatal("message over the bandwidth burst allowed")
+	}
+}
+
+func TestMessageSender(t *testing.T) {
+	a := network.MessageSender(from("127.0.0.1", 50001))
+	b := network.MessageSender(from("127.0.0.1", 50002))
+	if a != "127.0.0.1:50001" || b != "127.0.0.1:50002" {
+		t.Fatalf("local connections charged as %q and %q", a, b)
+	}
+	if got := network.MessageSender(from("10.0.0.1", 50004)); got != "10.0.0.1" {
+		t.Fatalf("remote message charged to %q, want its host", got)
+	}
+
+	// A loopback connection's allowance goes with it.
+	cl := network.NewConnLimiter()
+	conn := from("127.0.0.1", 50001)
+	cl.Acquire(conn)
+	cl.AllowMessage(network.MessageSender(conn), 100)
+	cl.Release(conn)
+	if n := cl.Tracked(); n != 0 {
+		t.Fatalf("%d senders tracked after the connection closed", n)
+	}
+}
+
+// Senders that keep changing can't grow the rate limit state without bound.
+func TestTrackedPeersCap(t *testing.T) {
+	cl := network.NewConnLimiter()
+	for i := 0; i < network.MaxTrackedPeers+100; i++ {
+		cl.AllowMessage(fmt.Sprintf("peer%d", i), 100)
+	}
+	if n := cl.Tracked(); n != network.MaxTrackedPeers {
+		t.Fatalf("tracked %d senders, want %d", n, network.MaxTrackedPeers)
+	}
+}
+
+// Messages read at once share one byte budget, whoever sends them.
+func TestBufferBudget(t *testing.T) {
+	cl := network.NewConnLimiter()
+	full := strings.Repeat("x", network.MaxMessageSize)
+
+	var readers []interface{ Release() }
+	for read := 0; read+network.MaxMessageSize <= network.MaxBufferedBytes; read += network.MaxMessageSize {
+		r := cl.Reader(strings.NewReader(full))
+		if _, err := io.ReadAll(r); err != nil {
+			t.Fatalf("after %d bytes: %v", read, err)
+		}
+		readers = append(readers, r)
+	}
+
+	r := cl.Reader(strings.NewReader("x"))
+	if _, err := io.ReadAll(r); !errors.Is(err, network.ErrBufferFull) {
+		t.Fatalf("read past the budget: %v", err)
+	}
+	r.Release()
+
+	readers[0].Release()
+	if _, err := io.ReadAll(cl.Reader(strings.NewReader(full))); err != nil {
+		t.Fatalf("released budget was not reused: %v", err)
+	}
+}
+
+func TestRequestTracker(t *testing.T) {
+	rt := network.NewRequestTracker()
+	if !rt.Add("block01") || rt.Add("block01") {
+		t.Fatal("outstanding request was sent twice")
+	}
+	rt.Done("block01")
+	if !rt.Add("block01") {
+		t.Fatal("request could not be sent again after it was answered")
+	}
+
+	for i := 1; i < 5000; i++ {
+		rt.Add("tx" + hex.EncodeToString([]byte{byte(i), byte(i >> 8)}))
+	}
+	if rt.Add("block02") {
+		t.Fatal("tracker grew past its cap")
+	}
+}
+
+func TestBlockRetry(t *testing.T) {
+	a, b := []byte{0xaa}, []byte{0xbb}
+	network.SetBlocksInTransit([][]byte{a, b}, "localhost:3001")
+	defer network.SetBlocksInTransit(nil, "")
+
+	if hash, peer := network.NextBlockInTransit(); hex.EncodeToString(hash) != "aa" || peer != "localhost:3001" {
+		t.Fatalf("first request is %x to %s", hash, peer)
+	}
+	if hash, _ := network.NextBlockInTransit(); hash != nil {
+		t.Fatalf("requested %x while aa is outstanding", hash)
+	}
+
+	network.ExpireRequest("blockaa")
+	if hash, _ := network.NextBlockInTransit(); hex.EncodeToString(hash) != "aa" {
+		t.Fatalf("expired request retried as %x", hash)
+	}
+
+	network.ReceivedInTransit(a)
+	if hash, _ := network.NextBlockInTransit(); hex.EncodeToString(hash) != "bb" {
+		t.Fatalf("next request is %x, want bb", hash)
+	}
+	network.ExpireRequest("blockbb")
+}
@@ -0,0 +1,13 @@
+package network
+
+import (
+	"github.com/FG420/go-block/logging"
+)
+
+var (
+	netLog     = logging.Get(logging.Net)
+	mempoolLog = logging.Get(logging.Mempool)
+	minerLog   = logging.Get(logging.Miner)
+	chainLog   = logging.Get(logging.Chain)
+	walletLog  = logging.Get(logging.Wallet)
+)
@@ -0,0 +1,163 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"sync"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/events"
+)
+
+type Mempool struct {
+	mu      sync.RWMutex
+	txs     map[string]blockchain.Transaction
+	changed chan struct{}
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{
+		txs:     make(map[string]blockchain.Transaction),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Changed delivers a notification after the pool has been modified. Bursts of
+// changes are coalesced into a single notification.
+func (mp *Mempool) Changed() <-chan struct{} {
+	return mp.changed
+}
+
+func (mp *Mempool) notify() {
+	select {
+	case mp.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (mp *Mempool) Add(tx blockchain.Transaction) bool {
+	txID := hex.EncodeToString(tx.ID)
+
+	mp.mu.Lock()
+	if _, ok := mp.txs[txID]; ok || mp.conflicts(&tx) {
+		mp.mu.Unlock()
+		return false
+	}
+	mp.txs[txID] = tx
+	mp.mu.Unlock()
+
+	events.Publish(events.Event{
+		Type:      events.TxAccepted,
+		TxID:      txID,
+		Addresses: tx.Addresses(),
+	})
+	mp.notify()
+	return true
+}
+
+func (mp *Mempool) conflicts(tx *blockchain.Transaction) bool {
+	for _, poolTx := range mp.txs {
+		for _, poolIn := range poolTx.Inputs {
+			for _, in := range tx.Inputs {
+				if in.Out == poolIn.Out && bytes.Equal(in.ID, poolIn.ID) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (mp *Mempool) Get(id []byte) (blockchain.Transaction, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	tx, ok := mp.txs[hex.EncodeToString(id)]
+	return tx, ok
+}
+
+func (mp *Mempool) Has(id []byte) bool {
+	_, ok := mp.Get(id)
+	return ok
+}
+
+func (mp *Mempool) Remove(txs []*blockchain.Transaction) {
+	mp.mu.Lock()
+	for _, tx := range txs {
+		delete(mp.txs, hex.EncodeToString(tx.ID))
+	}
+	mp.mu.Unlock()
+
+	mp.notify()
+}
+
+func (mp *Mempool) Transactions() []blockchain.Transaction {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	txs := make([]blockchain.Transaction, 0, len(mp.txs))
+	for _, tx := range mp.txs {
+		txs = append(txs, tx)
+	}
+
+	return txs
+}
+
+func (mp *Mempool) Count() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.txs)
+}
+
+func (mp *Mempool) Bytes() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	size := 0
+	for _, tx := range mp.txs {
+		size += len(tx.Serialize())
+	}
+
+	return size
+}
+
+// SaveFile writes the pool's transactions to path so they survive a restart.
+func (mp *Mempool) SaveFile(path string) error {
+	var txs [][]byte
+	for _, tx := range mp.Transactions() {
+		txs = append(txs, tx.Serialize())
+	}
+
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(txs); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buff.Bytes(), 0644)
+}
+
+func LoadMempool(path string) ([]blockchain.Transaction, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data [][]byte
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	var txs []blockchain.Transaction
+	for _, txData := range data {
+		txs = append(txs, blockchain.DeserializeTransaction(txData))
+	}
+
+	return txs, nil
+}
@@ -0,0 +1,20 @@
+package network
+
+import (
+	"github.com/FG420/go-block/metrics"
+)
+
+var (
+	messagesReceived = metrics.NewCounterVec("messages_received_total", "Peer messages received by command.", "command")
+	invalidMessages  = metrics.NewCounterVec("invalid_messages_total", "Peer messages dropped or rejected by reason.", "reason")
+
+	_ = metrics.NewGaugeFunc("peers_connected", "Peers the node is connected to.", func() float64 {
+		return float64(len(Nodes()))
+	})
+	_ = metrics.NewGaugeFunc("mempool_transactions", "Transactions in the mempool.", func() float64 {
+		return float64(memoryPool.Count())
+	})
+	_ = metrics.NewGaugeFunc("mempool_bytes", "Serialized size of the mempool.", func() float64 {
+		return float64(memoryPool.Bytes())
+	})
+)
@@ -2,18 +2,19 @@ package network
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
-	"runtime"
-	"syscall"
-
-	"github.com/vrecan/death/v3"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/events"
 	"github.com/FG420/go-block/handlers"
 )
 
@@ -25,13 +26,45 @@ const (
 
 var (
 	nodeAddr        string
+	listenAddr      string
 	minerAddr       string
+	connectOnly     bool
+	DefaultSeeds    = []string{"localhost:3000"}
 	KnownNodes      = []string{"localhost:3000"}
+	nodesMu         sync.RWMutex
+	addrBook        = NewAddrBook("")
 	blocksInTransit = [][]byte{}
-	memoryPool      = make(map[string]blockchain.Transaction)
+	transitPeer     string
+	transitMu       sync.Mutex
+	memoryPool      = NewMempool()
 )
 
 type (
+	Config struct {
+		NodeID       string
+		MinerAddr    string
+		ListenAddr   string
+		ExternalAddr string
+		Connect      []string
+		AddNodes     []string
+		SeedsFile    string
+		Encrypt      bool
+		AllowedPeers []string
+		// RebroadcastBlocks is how many blocks a wallet transaction may stay
+		// unconfirmed before it is broadcast again, DefaultRebroadcastBlocks
+		// when 0.
+		RebroadcastBlocks int
+		// Services are stopped first on shutdown, so none of their calls is
+		// still writing to the database while the node drains.
+		Services []Service
+	}
+
+	// Service is a server running alongside the node, such as the RPC
+	// server.
+	Service interface {
+		Shutdown(ctx context.Context) error
+	}
+
 	Addr struct {
 		AddrList []string
 	}
@@ -41,6 +74,10 @@ type (
 		Block    []byte
 	}
 
+	GetAddr struct {
+		AddrFrom string
+	}
+
 	GetBlocks struct {
 		AddrFrom string
 	}
@@ -89,14 +126,8 @@ func BytesToCmd(bytes []byte) string {
 	return fmt.Sprintf("%s", cmd)
 }
 
-func CloseDB(chain *blockchain.BlockChain) {
-	d := death.NewDeath(syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-
-	d.WaitForDeathWithFunc(func() {
-		defer os.Exit(1)
-		defer runtime.Goexit()
-		chain.Database.Close()
-	})
+func TxPool() *Mempool {
+	return memoryPool
 }
 
 func GobEncode(data interface{}) []byte {
@@ -110,12 +141,22 @@ func GobEncode(data interface{}) []byte {
 }
 
 func RequestBlocks() {
-	for _, node := range KnownNodes {
+	for _, node := range Nodes() {
 		SendGetBlocks(node)
 	}
 }
 
+func Nodes() []string {
+	nodesMu.RLock()
+	defer nodesMu.RUnlock()
+
+	return append([]string(nil), KnownNodes...)
+}
+
 func NodeIsKnown(addr string) bool {
+	nodesMu.RLock()
+	defer nodesMu.RUnlock()
+
 	for _, node := range KnownNodes {
 		if node == addr {
 			return true
@@ -125,19 +166,173 @@ func NodeIsKnown(addr string) bool {
 	return false
 }
 
+func AddNode(addr string) bool {
+	if addr == "" || IsSelf(addr) {
+		return false
+	}
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+
+	for _, node := range KnownNodes {
+		if node == addr {
+			return false
+		}
+	}
+	KnownNodes = append(KnownNodes, addr)
+
+	return true
+}
+
+func RemoveNode(addr string) {
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+
+	var updatedNodes []string
+	for _, node := range KnownNodes {
+		if node != addr {
+			updatedNodes = append(updatedNodes, node)
+		}
+	}
+	KnownNodes = updatedNodes
+}
+
+// IsSelf reports whether addr points back at this node, either through the
+// advertised address or through a loopback/local interface on our listen port.
+func IsSelf(addr string) bool {
+	if addr == nodeAddr || addr == listenAddr {
+		return true
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	_, listenPort, err := net.SplitHostPort(listenAddr)
+	if err != nil || port != listenPort {
+		return false
+	}
+
+	if host == "" || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, local := range localIPs() {
+		if local.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func localIPs() []net.IP {
+	var ips []net.IP
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+
+	return ips
+}
+
+// ExternalAddress picks the address a node advertises to its peers. An
+// explicit external address wins; otherwise the listen address is used unless
+// it binds every interface, in which case the first routable local IP is used.
+func ExternalAddress(listen, external string) (string, error) {
+	_, listenPort, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+
+	if external != "" {
+		if _, _, err := net.SplitHostPort(external); err != nil {
+			external = net.JoinHostPort(external, listenPort)
+		}
+		return external, nil
+	}
+
+	host, _, _ := net.SplitHostPort(listen)
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return listen, nil
+	}
+
+	for _, ip := range localIPs() {
+		if ip.To4() != nil && ip.IsGlobalUnicast() {
+			return net.JoinHostPort(ip.String(), listenPort), nil
+		}
+	}
+
+	return net.JoinHostPort("localhost", listenPort), nil
+}
+
 func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
-	req, err := io.ReadAll(conn)
 	defer conn.Close()
-	handlers.HandleErr(err)
+	defer func() {
+		if r := recover(); r != nil {
+			invalidMessages.Inc("malformed")
+			netLog.Warn("invalid message", "peer", conn.RemoteAddr().String(), "err", r)
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	body := limiter.reader(conn)
+	defer body.release()
+	req, err := io.ReadAll(io.LimitReader(body, MaxMessageSize+1))
+	if errors.Is(err, errBufferFull) {
+		invalidMessages.Inc("ratelimit")
+		netLog.Warn("dropped message", "peer", conn.RemoteAddr().String(), "err", err)
+		return
+	}
+	if err != nil {
+		netLog.Debug("dropped connection", "peer", conn.RemoteAddr().String(), "err", err)
+		return
+	}
+	if len(req) < cmdLength || len(req) > MaxMessageSize {
+		invalidMessages.Inc("size")
+		netLog.Warn("dropped message", "peer", conn.RemoteAddr().String(), "bytes", len(req))
+		return
+	}
+	if sender := messageSender(conn); !limiter.allowMessage(sender, len(req)) {
+		invalidMessages.Inc("ratelimit")
+		netLog.Warn("rate limited", "peer", sender)
+		return
+	}
 
 	cmd := BytesToCmd(req[:cmdLength])
-	fmt.Printf("Received %s command\n", cmd)
+	if id := PeerIdentity(conn); id != "" {
+		netLog.Debug("received command", "cmd", cmd, "peer", id)
+	} else {
+		netLog.Debug("received command", "cmd", cmd, "peer", conn.RemoteAddr().String())
+	}
 
 	switch cmd {
 	case "addr":
 		HandleAddr(req)
 	case "block":
 		HandleBlock(req, chain)
+	case "cmpctblock":
+		HandleCompactBlock(req, chain)
+	case "getblocktxn":
+		HandleGetBlockTxn(req, chain)
+	case "blocktxn":
+		HandleBlockTxn(req, chain)
+	case "getaddr":
+		HandleGetAddr(req)
 	case "inv":
 		HandleInv(req, chain)
 	case "getblocks":
@@ -149,31 +344,160 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 	case "version":
 		HandleVersion(req, chain)
 	default:
-		fmt.Println("Unknown Command")
+		invalidMessages.Inc("unknown")
+		netLog.Warn("unknown command", "cmd", cmd, "peer", conn.RemoteAddr().String())
+		return
 	}
+	messagesReceived.Inc(cmd)
 }
 
-func StartServer(nodeId, mineraddr string) {
-	nodeAddr = fmt.Sprintf("localhost:%s", nodeId)
-	minerAddr = mineraddr
+// StartServer runs the node until ctx is cancelled or Stop is called. It then
+// stops accepting connections, cancels mining, waits for in-flight messages
+// and flushes the node's state before returning.
+func StartServer(ctx context.Context, chain *blockchain.BlockChain, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// A node that fails to come up still stops the services it was given.
+	up := false
+	defer func() {
+		if !up {
+			stopServices(cfg.Services)
+		}
+	}()
 
-	ln, err := net.Listen(protocol, nodeAddr)
-	handlers.HandleErr(err)
-	defer ln.Close()
+	listenAddr = cfg.ListenAddr
+	if listenAddr == "" {
+		listenAddr = ":" + cfg.NodeID
+	}
+	minerAddr = cfg.MinerAddr
 
-	chain := blockchain.ContinueBlockChain(nodeId)
-	defer chain.Database.Close()
-	go CloseDB(chain)
+	addr, err := ExternalAddress(listenAddr, cfg.ExternalAddr)
+	if err != nil {
+		return err
+	}
+	nodeAddr = addr
+
+	if cfg.Encrypt {
+		identity, err := LoadIdentity(NodeKeyPath(cfg.NodeID))
+		if err != nil {
+			return err
+		}
+		secure, err := NewSecureTransport(identity, cfg.AllowedPeers)
+		if err != nil {
+			return err
+		}
+		SetTransport(secure)
+		netLog.Info("encrypted transport enabled", "identity", secure.Identity())
+	}
+
+	seedsPath := cfg.SeedsFile
+	if seedsPath == "" {
+		seedsPath = fmt.Sprintf(seedsFile, cfg.NodeID)
+	}
+	seeds, err := LoadSeeds(seedsPath)
+	if err != nil {
+		return err
+	}
+
+	ln, err := transport.Listen(listenAddr)
+	if err != nil {
+		return err
+	}
+	netLog.Info("listening", "addr", ln.Addr().String(), "advertised", nodeAddr)
+	up = true
+
+	stopMu.Lock()
+	stopNode = cancel
+	stopMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	addrBook = NewAddrBook(cfg.NodeID)
+	if err := addrBook.LoadFile(); err != nil {
+		netLog.Error("could not load peers", "err", err)
+	}
+	restoreMempool(chain, cfg.NodeID)
+
+	peers := cfg.AddNodes
+	if len(cfg.Connect) > 0 {
+		peers = cfg.Connect
+		connectOnly = true
+	} else {
+		if addrBook.Len() == 0 {
+			for _, seed := range seeds {
+				addrBook.Add(strings.TrimSpace(seed))
+			}
+		}
+		peers = append(peers, addrBook.Best(maxOutbound)...)
+	}
+
+	nodesMu.Lock()
+	KnownNodes = nil
+	nodesMu.Unlock()
+	for _, node := range peers {
+		AddNode(strings.TrimSpace(node))
+	}
+
+	for _, node := range Nodes() {
+		SendVersion(node, chain)
+		if !connectOnly && addrBook.Len() < maxAddrPerMsg {
+			SendGetAddr(node)
+		}
+	}
 
-	if nodeAddr != KnownNodes[0] {
-		SendVersion(KnownNodes[0], chain)
+	var workers sync.WaitGroup
+	if !connectOnly {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			GossipAddrs(ctx)
+		}()
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		RetryBlocks(ctx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		WatchWallet(ctx, chain, cfg.NodeID, cfg.RebroadcastBlocks)
+	}()
+	if len(minerAddr) > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			Mine(ctx, chain)
+		}()
 	}
 
 	for {
 		conn, err := ln.Accept()
-		handlers.HandleErr(err)
-		go HandleConnection(conn, chain)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			netLog.Error("accept failed", "err", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if !limiter.acquire(conn) {
+			conn.Close()
+			continue
+		}
+		inflight.Add(1)
+		go func() {
+			defer inflight.Done()
+			defer limiter.release(conn)
+			HandleConnection(conn, chain)
+		}()
 	}
+
+	return shutdown(chain, &workers, cfg)
 }
 
 func HandleAddr(req []byte) {
@@ -185,9 +509,35 @@ func HandleAddr(req []byte) {
 	err := dec.Decode(&payload)
 	handlers.HandleErr(err)
 
-	KnownNodes = append(KnownNodes, payload.AddrList...)
-	fmt.Printf("There are %d known nodes\n", len(KnownNodes))
-	RequestBlocks()
+	var added []string
+	if !connectOnly {
+		for _, addr := range payload.AddrList {
+			addrBook.Add(addr)
+			if len(Nodes()) < maxOutbound && AddNode(addr) {
+				added = append(added, addr)
+			}
+		}
+	}
+	netLog.Debug("received addresses", "count", len(payload.AddrList), "new", len(added), "nodes", len(Nodes()))
+
+	// Peers we already know announce their blocks, only new ones need
+	// asking.
+	for _, node := range added {
+		SendGetBlocks(node)
+	}
+}
+
+func HandleGetAddr(req []byte) {
+	var buff bytes.Buffer
+	var payload GetAddr
+
+	buff.Write(req[cmdLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	handlers.HandleErr(err)
+
+	addrBook.Seen(payload.AddrFrom)
+	SendAddr(payload.AddrFrom)
 }
 
 func HandleBlock(req []byte, chain *blockchain.BlockChain) {
@@ -202,20 +552,47 @@ func HandleBlock(req []byte, chain *blockchain.BlockChain) {
 	blockData := payload.Block
 	block := blockchain.Deserialize(blockData)
 
-	fmt.Println("Received a new block.")
-
-	chain.AddBlock(block)
+	markKnown(payload.AddrFrom, block.Hash)
+	requests.done("block" + hex.EncodeToString(block.Hash))
+	receivedInTransit(block.Hash)
 
-	fmt.Printf("Added block %x\n", block.Hash)
+	ProcessBlock(chain, block, payload.AddrFrom, len(blockData))
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
+	if blockHash, _ := nextBlockInTransit(); blockHash != nil {
 		SendGetData(payload.AddrFrom, "block", blockHash)
+	} else if !syncing() {
+		reindexUTXO(chain)
+		Announce("block", chain.LastHash)
+	}
+}
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		utxoSet := blockchain.UTXOSet{BlockChain: chain}
-		utxoSet.Reindex()
+// ProcessBlock connects block to the chain. A block with an unknown parent is
+// parked in the orphan pool and its missing ancestor requested from the peer
+// that sent it; once a block connects, any orphans waiting on it follow.
+func ProcessBlock(chain *blockchain.BlockChain, block *blockchain.Block, from string, size int) {
+	err := chain.AddBlock(block)
+	if errors.Is(err, blockchain.ErrOrphanBlock) {
+		if orphans.Add(block, from, size) {
+			netLog.Info("orphan block", "hash", hex.EncodeToString(block.Hash), "orphans", orphans.Count())
+		}
+		if parent := orphans.MissingAncestor(block.Hash); parent != nil &&
+			requests.add("block"+hex.EncodeToString(parent)) {
+			SendGetData(from, "block", parent)
+		}
+		return
+	}
+	if err != nil {
+		invalidMessages.Inc("rejected")
+		chainLog.Warn("could not add block", "hash", hex.EncodeToString(block.Hash), "err", err)
+		return
+	}
+
+	utxoStale.Store(true)
+	memoryPool.Remove(block.Transactions)
+	chainLog.Info("added block", "hash", hex.EncodeToString(block.Hash), "height", block.Height, "txs", len(block.Transactions))
+
+	for _, child := range orphans.TakeChildren(block.Hash) {
+		ProcessBlock(chain, child.block, child.from, child.size)
 	}
 }
 
@@ -241,19 +618,23 @@ func HandleGetData(req []byte, chain *blockchain.BlockChain) {
 	err := dec.Decode(&payload)
 	handlers.HandleErr(err)
 
-	if payload.Type == "block" {
+	if payload.Type == "block" || payload.Type == "cmpctblock" {
 		block, err := chain.GetBlock([]byte(payload.ID))
 		if err != nil {
 			return
 		}
-		SendBlock(payload.AddrFrom, block)
+		if payload.Type == "cmpctblock" {
+			SendCompactBlock(payload.AddrFrom, block)
+		} else {
+			SendBlock(payload.AddrFrom, block)
+		}
 	}
 
 	if payload.Type == "tx" {
-		txId := hex.EncodeToString(payload.ID)
-		tx := memoryPool[txId]
-
-		SendTx(payload.AddrFrom, &tx)
+		if tx, ok := memoryPool.Get(payload.ID); ok {
+			markKnown(payload.AddrFrom, tx.ID)
+			SendTx(payload.AddrFrom, &tx)
+		}
 	}
 }
 
@@ -275,8 +656,9 @@ func HandleVersion(req []byte, chain *blockchain.BlockChain) {
 		SendVersion(payload.AddrFrom, chain)
 	}
 
-	if !NodeIsKnown(payload.AddrFrom) {
-		KnownNodes = append(KnownNodes, payload.AddrFrom)
+	addrBook.Seen(payload.AddrFrom)
+	if AddNode(payload.AddrFrom) {
+		SendAddr(payload.AddrFrom)
 	}
 }
 
@@ -291,62 +673,107 @@ func HandleTx(req []byte, chain *blockchain.BlockChain) {
 
 	txData := payload.Transaction
 	tx := blockchain.DeserializeTransaction(txData)
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
+	markKnown(payload.AddrFrom, tx.ID)
+	requests.done("tx" + hex.EncodeToString(tx.ID))
 
-	fmt.Printf("%s, %d", nodeAddr, len(memoryPool))
+	if err := AcceptTx(chain, &tx); err != nil {
+		invalidMessages.Inc("rejected")
+		mempoolLog.Info("rejected transaction", "txid", hex.EncodeToString(tx.ID), "err", err)
+		return
+	}
+	mempoolLog.Info("accepted transaction", "txid", hex.EncodeToString(tx.ID), "size", memoryPool.Count())
+}
 
-	if nodeAddr == KnownNodes[0] {
-		for _, node := range KnownNodes {
-			if node != nodeAddr && node != payload.AddrFrom {
-				SendInv(node, "tx", [][]byte{tx.ID})
+// AcceptTx validates tx against the chain and the mempool, adds it to the pool
+// and announces it to every peer that doesn't have it yet.
+func AcceptTx(chain *blockchain.BlockChain, tx *blockchain.Transaction) error {
+	if memoryPool.Has(tx.ID) {
+		return errors.New("transaction already in mempool")
+	}
+	if tx.IsCoinbase() || !chain.VerifyTransaction(tx) {
+		return errors.New("invalid transaction")
+	}
+	if !memoryPool.Add(*tx) {
+		return errors.New("transaction conflicts with the mempool")
+	}
+
+	Announce("tx", tx.ID)
+	return nil
+}
+
+// Mine rebuilds a block template from the mempool every time it changes,
+// until ctx is cancelled.
+func Mine(ctx context.Context, chain *blockchain.BlockChain) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-memoryPool.Changed():
+			if memoryPool.Count() > 0 {
+				MineTx(ctx, chain)
 			}
 		}
-	} else {
-		if len(memoryPool) >= 2 && len(minerAddr) > 0 {
-			MineTx(chain)
-		}
 	}
 }
 
-func MineTx(chain *blockchain.BlockChain) {
-	var txs []*blockchain.Transaction
+func MineTx(ctx context.Context, chain *blockchain.BlockChain) {
+	var txs, invalid []*blockchain.Transaction
 
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", memoryPool[id].ID)
-		tx := memoryPool[id]
+	for _, tx := range memoryPool.Transactions() {
 		if chain.VerifyTransaction(&tx) {
 			txs = append(txs, &tx)
+		} else {
+			invalid = append(invalid, &tx)
 		}
 	}
 
+	if len(invalid) > 0 {
+		minerLog.Warn("dropping invalid transactions", "count", len(invalid))
+		memoryPool.Remove(invalid)
+	}
+
 	if len(txs) == 0 {
-		fmt.Println("All Transactions are invalid")
 		return
 	}
+	minerLog.Info("mining block", "txs", len(txs))
 
 	cbTx := blockchain.CoinbaseTx(minerAddr, "")
 	txs = append(txs, cbTx)
 
-	newBlock := chain.MineBlock(txs)
-	utxoSet := blockchain.UTXOSet{BlockChain: chain}
-	utxoSet.Reindex()
-
-	fmt.Println("New Block mined")
+	// A block from a peer makes the template stale; Mine starts over on the
+	// new tip once the block's transactions leave the mempool.
+	mineCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub := events.DefaultBus.Subscribe(events.Filter{Types: []string{events.BlockConnected}})
+	defer sub.Close()
+	go func() {
+		select {
+		case <-sub.C:
+			cancel()
+		case <-mineCtx.Done():
+		}
+	}()
 
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(memoryPool, txID)
+	_, err := MineTransactions(mineCtx, chain, txs)
+	if err != nil && ctx.Err() == nil {
+		minerLog.Info("block template is stale, restarting", "err", err)
 	}
+}
 
-	for _, node := range KnownNodes {
-		if node != nodeAddr {
-			SendInv(node, "block", [][]byte{newBlock.Hash})
-		}
+// MineTransactions mines txs into a new block on top of the chain, drops them
+// from the mempool and announces the block. If ctx is cancelled first the
+// transactions stay in the mempool.
+func MineTransactions(ctx context.Context, chain *blockchain.BlockChain, txs []*blockchain.Transaction) (*blockchain.Block, error) {
+	newBlock, err := chain.MineBlockContext(ctx, txs)
+	if err != nil {
+		return nil, err
 	}
+	reindexUTXO(chain)
 
-	if len(memoryPool) > 0 {
-		MineTx(chain)
-	}
+	memoryPool.Remove(txs)
+	Announce("block", newBlock.Hash)
+
+	return newBlock, nil
 }
 
 func HandleInv(req []byte, chain *blockchain.BlockChain) {
@@ -358,69 +785,153 @@ func HandleInv(req []byte, chain *blockchain.BlockChain) {
 	err := dec.Decode(&payload)
 	handlers.HandleErr(err)
 
-	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
+	netLog.Debug("received inventory", "type", payload.Type, "items", len(payload.Items), "peer", payload.AddrFrom)
 
-	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+	if len(payload.Items) > maxInvItems {
+		netLog.Warn("ignoring oversized inventory", "items", len(payload.Items), "peer", payload.AddrFrom)
+		return
+	}
 
-		blockHash := payload.Items[0]
-		SendGetData(payload.AddrFrom, "block", blockHash)
+	for _, item := range payload.Items {
+		markKnown(payload.AddrFrom, item)
+	}
 
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+	if payload.Type == "block" {
+		// Inventories list the tip first; fetch the oldest missing block first
+		// so that blocks arrive with their parents already connected.
+		var missing [][]byte
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			item := payload.Items[i]
+			if !chain.HasBlock(item) && !orphans.Has(item) {
+				missing = append(missing, item)
 			}
 		}
-		blocksInTransit = newInTransit
+
+		// A single new block is most likely a freshly mined tip whose
+		// transactions we already hold, so ask for it in compact form.
+		if len(payload.Items) == 1 && len(missing) == 1 {
+			if requests.add("block" + hex.EncodeToString(missing[0])) {
+				SendGetData(payload.AddrFrom, "cmpctblock", missing[0])
+			}
+			return
+		}
+
+		transitMu.Lock()
+		blocksInTransit = missing
+		transitPeer = payload.AddrFrom
+		transitMu.Unlock()
+
+		if blockHash, _ := nextBlockInTransit(); blockHash != nil {
+			SendGetData(payload.AddrFrom, "block", blockHash)
+		}
 	}
 
 	if payload.Type == "tx" {
-		txID := payload.Items[0]
-
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
-			SendGetData(payload.AddrFrom, "tx", txID)
+		for _, txID := range payload.Items {
+			if !memoryPool.Has(txID) && requests.add("tx"+hex.EncodeToString(txID)) {
+				SendGetData(payload.AddrFrom, "tx", txID)
+			}
 		}
 	}
 }
 
-func ExtractCmd(req []byte) []byte {
-	return req[:cmdLength]
+// nextBlockInTransit returns the next block of the sync to request and the
+// peer that announced it, or nil while its request is outstanding. A block
+// stays queued until it arrives, so a lost or rate limited request is sent
+// again once it expires.
+func nextBlockInTransit() ([]byte, string) {
+	transitMu.Lock()
+	defer transitMu.Unlock()
+
+	if len(blocksInTransit) == 0 || !requests.add("block"+hex.EncodeToString(blocksInTransit[0])) {
+		return nil, ""
+	}
+	return blocksInTransit[0], transitPeer
 }
 
-func SendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
+func syncing() bool {
+	transitMu.Lock()
+	defer transitMu.Unlock()
 
-	if err != nil {
-		fmt.Printf("%s isn't available\n", addr)
+	return len(blocksInTransit) > 0
+}
 
-		var updatedNodes []string
+func receivedInTransit(hash []byte) {
+	transitMu.Lock()
+	defer transitMu.Unlock()
+
+	for i, blockHash := range blocksInTransit {
+		if bytes.Equal(blockHash, hash) {
+			blocksInTransit = append(blocksInTransit[:i:i], blocksInTransit[i+1:]...)
+			return
+		}
+	}
+}
 
-		for _, node := range KnownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
+// RetryBlocks re-requests the next block of a stalled sync, until ctx is
+// cancelled.
+func RetryBlocks(ctx context.Context) {
+	ticker := time.NewTicker(blockRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if blockHash, peer := nextBlockInTransit(); blockHash != nil {
+				netLog.Info("retrying block request", "hash", hex.EncodeToString(blockHash), "peer", peer)
+				SendGetData(peer, "block", blockHash)
 			}
 		}
+	}
+}
 
-		KnownNodes = updatedNodes
-		return
+func ExtractCmd(req []byte) []byte {
+	return req[:cmdLength]
+}
+
+// SendData delivers one message to addr. A peer that can't be reached is
+// dropped from the known nodes and the error returned.
+func SendData(addr string, data []byte) error {
+	conn, err := transport.Dial(addr)
+
+	if err != nil {
+		netLog.Info("peer unavailable", "peer", addr, "err", err)
+		RemoveNode(addr)
+		forgetPeer(addr)
+		addrBook.Failed(addr)
+		return fmt.Errorf("peer %s: %w", addr, err)
 	}
 
 	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	handlers.HandleErr(err)
+	conn.SetWriteDeadline(time.Now().Add(readTimeout))
+	if _, err := io.Copy(conn, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("peer %s: %w", addr, err)
+	}
+	addrBook.Good(addr)
+	return nil
 }
 
 func SendAddr(addr string) {
-	nodes := Addr{KnownNodes}
-	nodes.AddrList = append(nodes.AddrList, nodeAddr)
-	payload := GobEncode(nodes)
+	sendAddrList(addr, append(addrBook.Sample(maxAddrPerMsg-1), nodeAddr))
+}
+
+func sendAddrList(addr string, addrs []string) {
+	payload := GobEncode(Addr{addrs})
 	req := append(CmdToBytes("addr"), payload...)
 
 	SendData(addr, req)
 }
 
+func SendGetAddr(addr string) {
+	payload := GobEncode(GetAddr{nodeAddr})
+	req := append(CmdToBytes("getaddr"), payload...)
+
+	SendData(addr, req)
+}
+
 func SendBlock(addr string, b *blockchain.Block) {
 	data := Block{nodeAddr, b.Serialize()}
 	payload := GobEncode(data)
@@ -437,12 +948,30 @@ func SendInv(addr, kind string, items [][]byte) {
 	SendData(addr, req)
 }
 
-func SendTx(addr string, tnx *blockchain.Transaction) {
+func SendTx(addr string, tnx *blockchain.Transaction) error {
 	data := Tx{nodeAddr, tnx.Serialize()}
 	payload := GobEncode(data)
 	req := append(CmdToBytes("tx"), payload...)
 
-	SendData(addr, req)
+	return SendData(addr, req)
+}
+
+// BroadcastTx sends tx to every peer and fails only if none of them got it.
+func BroadcastTx(peers []string, tx *blockchain.Transaction) error {
+	if len(peers) == 0 {
+		return errors.New("no peers to relay to, pass -connect or -addnode")
+	}
+
+	var errs []error
+	for _, peer := range peers {
+		if err := SendTx(peer, tx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(peers) {
+		return fmt.Errorf("no peer could be reached: %w", errors.Join(errs...))
+	}
+	return nil
 }
 
 func SendVersion(addr string, chain *blockchain.BlockChain) {
@@ -463,7 +992,7 @@ func SendGetBlocks(addr string) {
 }
 
 func SendGetData(addr, kind string, id []byte) {
-	payload := GobEncode(GetData{id, kind, nodeAddr})
+	payload := GobEncode(GetData{ID: id, AddrFrom: nodeAddr, Type: kind})
 	req := append(CmdToBytes("getdata"), payload...)
 
 	SendData(addr, req)
@@ -0,0 +1,157 @@
+package network
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+)
+
+type (
+	orphanBlock struct {
+		block *blockchain.Block
+		from  string
+		size  int
+		added time.Time
+	}
+
+	// OrphanPool holds blocks whose parent hasn't arrived yet, bounded by
+	// count, total size and age.
+	OrphanPool struct {
+		mu       sync.Mutex
+		byHash   map[string]*orphanBlock
+		byParent map[string][]string
+		bytes    int
+		now      func() time.Time
+	}
+)
+
+var orphans = NewOrphanPool()
+
+func NewOrphanPool() *OrphanPool {
+	return &OrphanPool{
+		byHash:   make(map[string]*orphanBlock),
+		byParent: make(map[string][]string),
+		now:      time.Now,
+	}
+}
+
+func (op *OrphanPool) Add(block *blockchain.Block, from string, size int) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	hash := hex.EncodeToString(block.Hash)
+	if _, ok := op.byHash[hash]; ok || size > maxOrphanBytes {
+		return false
+	}
+
+	now := op.now()
+	op.expire(now)
+	for len(op.byHash) >= maxOrphanBlocks || op.bytes+size > maxOrphanBytes {
+		op.evictOldest()
+	}
+
+	parent := hex.EncodeToString(block.PrevHash)
+	op.byHash[hash] = &orphanBlock{block, from, size, now}
+	op.byParent[parent] = append(op.byParent[parent], hash)
+	op.bytes += size
+
+	return true
+}
+
+func (op *OrphanPool) Has(hash []byte) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	_, ok := op.byHash[hex.EncodeToString(hash)]
+	return ok
+}
+
+func (op *OrphanPool) Count() int {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	return len(op.byHash)
+}
+
+// MissingAncestor follows the orphan chain starting at hash back to the first
+// block we have neither stored nor pooled and returns its hash.
+func (op *OrphanPool) MissingAncestor(hash []byte) []byte {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	orphan, ok := op.byHash[hex.EncodeToString(hash)]
+	for ok {
+		parent := orphan.block.PrevHash
+		next, found := op.byHash[hex.EncodeToString(parent)]
+		if !found {
+			return parent
+		}
+		orphan = next
+	}
+
+	return nil
+}
+
+// TakeChildren removes and returns the orphans waiting on parent.
+func (op *OrphanPool) TakeChildren(parent []byte) []*orphanBlock {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	key := hex.EncodeToString(parent)
+	// remove shifts the byParent slice, so walk a copy of it.
+	hashes := append([]string{}, op.byParent[key]...)
+	var children []*orphanBlock
+	for _, hash := range hashes {
+		if orphan, ok := op.byHash[hash]; ok {
+			children = append(children, orphan)
+			op.remove(hash)
+		}
+	}
+	delete(op.byParent, key)
+
+	return children
+}
+
+func (op *OrphanPool) remove(hash string) {
+	orphan, ok := op.byHash[hash]
+	if !ok {
+		return
+	}
+	delete(op.byHash, hash)
+	op.bytes -= orphan.size
+
+	parent := hex.EncodeToString(orphan.block.PrevHash)
+	siblings := op.byParent[parent]
+	for i, sibling := range siblings {
+		if sibling == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(op.byParent, parent)
+	} else {
+		op.byParent[parent] = siblings
+	}
+}
+
+func (op *OrphanPool) expire(now time.Time) {
+	for hash, orphan := range op.byHash {
+		if now.Sub(orphan.added) > orphanTTL {
+			op.remove(hash)
+		}
+	}
+}
+
+func (op *OrphanPool) evictOldest() {
+	var oldest string
+	var oldestTime time.Time
+	for hash, orphan := range op.byHash {
+		if oldest == "" || orphan.added.Before(oldestTime) {
+			oldest, oldestTime = hash, orphan.added
+		}
+	}
+	op.remove(oldest)
+}
@@ -0,0 +1,109 @@
+package network_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/network"
+)
+
+func orphan(hash, parent byte) *blockchain.Block {
+	return &blockchain.Block{Hash: []byte{hash}, PrevHash: []byte{parent}}
+}
+
+func TestOrphanChildren(t *testing.T) {
+	op := network.NewOrphanPool()
+	for hash := byte(1); hash <= 4; hash++ {
+		if !op.Add(orphan(hash, 0), "peer", 100) {
+			t.Fatalf("orphan %d not added", hash)
+		}
+	}
+	op.Add(orphan(5, 1), "peer", 100)
+	if op.Add(orphan(2, 0), "peer", 100) {
+		t.Fatal("duplicate orphan added")
+	}
+
+	children := network.OrphanBlocks(op.TakeChildren([]byte{0}))
+	if len(children) != 4 {
+		t.Fatalf("got %d children, want 4", len(children))
+	}
+	for i, child := range children {
+		if child.Hash[0] != byte(i+1) {
+			t.Errorf("child %d is %x", i, child.Hash)
+		}
+	}
+	if op.Count() != 1 || !op.Has([]byte{5}) {
+		t.Fatalf("%d orphans left, want only the grandchild", op.Count())
+	}
+	if len(op.TakeChildren([]byte{0})) != 0 {
+		t.Fatal("children taken twice")
+	}
+}
+
+func TestOrphanMissingAncestor(t *testing.T) {
+	op := network.NewOrphanPool()
+	op.Add(orphan(2, 1), "peer", 100)
+	op.Add(orphan(3, 2), "peer", 100)
+
+	if missing := op.MissingAncestor([]byte{3}); len(missing) != 1 || missing[0] != 1 {
+		t.Fatalf("missing ancestor %x, want 01", missing)
+	}
+	if op.MissingAncestor([]byte{9}) != nil {
+		t.Fatal("unknown orphan has a missing ancestor")
+	}
+}
+
+func TestOrphanExpiry(t *testing.T) {
+	now := time.Now()
+	op := network.NewOrphanPool()
+	op.SetClock(func() time.Time { return now })
+
+	op.Add(orphan(1, 0), "peer", 100)
+	now = now.Add(network.OrphanTTL / 2)
+	op.Add(orphan(2, 0), "peer", 100)
+
+	// Expiry runs on the next Add.
+	now = now.Add(network.OrphanTTL/2 + time.Second)
+	op.Add(orphan(3, 9), "peer", 100)
+
+	if op.Has([]byte{1}) || !op.Has([]byte{2}) || !op.Has([]byte{3}) {
+		t.Fatal("only the first orphan should have expired")
+	}
+	if children := op.TakeChildren([]byte{0}); len(children) != 1 {
+		t.Fatalf("got %d children of an expired parent entry, want 1", len(children))
+	}
+}
+
+func TestOrphanLimits(t *testing.T) {
+	now := time.Now()
+	op := network.NewOrphanPool()
+	op.SetClock(func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	})
+
+	if op.Add(orphan(1, 0), "peer", network.MaxOrphanBytes+1) {
+		t.Fatal("orphan over the byte cap added")
+	}
+
+	half := network.MaxOrphanBytes / 2
+	op.Add(orphan(1, 0), "peer", half)
+	op.Add(orphan(2, 0), "peer", half)
+	op.Add(orphan(3, 0), "peer", half)
+	if op.Has([]byte{1}) || !op.Has([]byte{2}) || !op.Has([]byte{3}) {
+		t.Fatal("the byte cap should evict the oldest orphan")
+	}
+
+	op = network.NewOrphanPool()
+	op.SetClock(func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	})
+	for i := 0; i <= network.MaxOrphanBlocks; i++ {
+		op.Add(&blockchain.Block{Hash: []byte{byte(i), byte(i >> 8)}, PrevHash: []byte{0}}, "peer", 1)
+	}
+	if op.Count() != network.MaxOrphanBlocks || op.Has([]byte{0, 0}) {
+		t.Fatalf("%d orphans, want %d without the oldest", op.Count(), network.MaxOrphanBlocks)
+	}
+}
@@ -0,0 +1,131 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+)
+
+const (
+	mempoolFile  = "./tmp/mempool_%s.dat"
+	drainTimeout = 10 * time.Second
+)
+
+var (
+	// ErrDrainTimeout is returned by StartServer when goroutines were still
+	// running at exit. They may be writing to the database, which must then
+	// be left open rather than closed under them.
+	ErrDrainTimeout = errors.New("node did not stop in time")
+
+	stopMu   sync.Mutex
+	stopNode context.CancelFunc
+	inflight sync.WaitGroup
+
+	// utxoStale is set once blocks have been connected without the UTXO set
+	// being rebuilt yet.
+	utxoStale atomic.Bool
+)
+
+// Stop makes a running StartServer shut down and return.
+func Stop() {
+	stopMu.Lock()
+	defer stopMu.Unlock()
+
+	if stopNode != nil {
+		stopNode()
+	}
+}
+
+func reindexUTXO(chain *blockchain.BlockChain) {
+	utxoStale.Store(false)
+	utxoSet := blockchain.UTXOSet{BlockChain: chain}
+	utxoSet.Reindex()
+}
+
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// stopServices shuts the services down, giving their calls drainTimeout to
+// finish.
+func stopServices(services []Service) []error {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, service := range services {
+		if err := service.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %T: %w", ErrDrainTimeout, service, err))
+		}
+	}
+	return errs
+}
+
+func restoreMempool(chain *blockchain.BlockChain, nodeId string) {
+	txs, err := LoadMempool(fmt.Sprintf(mempoolFile, nodeId))
+	if err != nil {
+		mempoolLog.Error("could not load mempool", "err", err)
+		return
+	}
+
+	restored := 0
+	for i := range txs {
+		if chain.VerifyTransaction(&txs[i]) && memoryPool.Add(txs[i]) {
+			restored++
+		}
+	}
+	if len(txs) > 0 {
+		mempoolLog.Info("restored mempool", "txs", restored, "dropped", len(txs)-restored)
+	}
+}
+
+// shutdown stops the services, waits for the node's goroutines and in-flight
+// handlers, then flushes the mempool, the UTXO set and the address book so
+// that the database can be closed. If any of them doesn't stop in time the
+// UTXO set is left alone and ErrDrainTimeout is returned.
+func shutdown(chain *blockchain.BlockChain, workers *sync.WaitGroup, cfg Config) error {
+	netLog.Info("shutting down")
+	nodeId := cfg.NodeID
+
+	errs := stopServices(cfg.Services)
+	if !waitTimeout(workers, drainTimeout) {
+		errs = append(errs, fmt.Errorf("%w: background workers still running", ErrDrainTimeout))
+	}
+	if !waitTimeout(&inflight, drainTimeout) {
+		errs = append(errs, fmt.Errorf("%w: message handlers still running", ErrDrainTimeout))
+	}
+	drained := len(errs) == 0
+
+	if err := memoryPool.SaveFile(fmt.Sprintf(mempoolFile, nodeId)); err != nil {
+		errs = append(errs, fmt.Errorf("saving mempool: %w", err))
+	} else if count := memoryPool.Count(); count > 0 {
+		mempoolLog.Info("saved mempool", "txs", count)
+	}
+
+	if drained && utxoStale.Load() {
+		chainLog.Info("rebuilding UTXO set before exit")
+		reindexUTXO(chain)
+	}
+
+	if err := addrBook.SaveFile(); err != nil {
+		errs = append(errs, fmt.Errorf("saving peers: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
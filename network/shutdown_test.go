@@ -0,0 +1,38 @@
+package network_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FG420/go-block/network"
+)
+
+type service struct {
+	stopped *[]string
+	name    string
+	err     error
+}
+
+func (s service) Shutdown(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("no deadline")
+	}
+	*s.stopped = append(*s.stopped, s.name)
+	return s.err
+}
+
+// A service that doesn't stop in time keeps the database from being closed.
+func TestStopServices(t *testing.T) {
+	var stopped []string
+	errs := network.StopServices([]network.Service{
+		service{&stopped, "rpc", nil},
+		service{&stopped, "explorer", context.DeadlineExceeded},
+	})
+	if len(stopped) != 2 || stopped[0] != "rpc" {
+		t.Fatalf("stopped %v", stopped)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], network.ErrDrainTimeout) || !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Fatalf("got %v", errs)
+	}
+}
@@ -0,0 +1,187 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	nodeKeyFile = "./tmp/nodekey_%s"
+
+	// dialTimeout bounds connecting to a peer, TLS handshake included.
+	dialTimeout = 10 * time.Second
+)
+
+type (
+	Transport interface {
+		Listen(addr string) (net.Listener, error)
+		Dial(addr string) (net.Conn, error)
+	}
+
+	plainTransport struct{}
+
+	// SecureTransport runs the peer protocol over mutually authenticated TLS
+	// 1.3. Each node presents a self-signed certificate for its ed25519
+	// identity key; peers are identified by the hex encoded public key and may
+	// be restricted to a pinned set.
+	SecureTransport struct {
+		identity ed25519.PrivateKey
+		cert     tls.Certificate
+		allowed  map[string]bool
+	}
+)
+
+var transport Transport = plainTransport{}
+
+func SetTransport(t Transport) {
+	transport = t
+}
+
+func (plainTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen(protocol, addr)
+}
+
+func (plainTransport) Dial(addr string) (net.Conn, error) {
+	return net.DialTimeout(protocol, addr, dialTimeout)
+}
+
+func IdentityOf(pub ed25519.PublicKey) string {
+	return hex.EncodeToString(pub)
+}
+
+// LoadIdentity reads the node identity key from path, generating and storing
+// a new one if the file doesn't exist yet.
+func LoadIdentity(path string) (ed25519.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		seed := hex.EncodeToString(key.Seed())
+		if err := os.WriteFile(path, []byte(seed+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid node key in %s", path)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func NodeKeyPath(nodeId string) string {
+	return fmt.Sprintf(nodeKeyFile, nodeId)
+}
+
+func NewSecureTransport(identity ed25519.PrivateKey, allowedPeers []string) (*SecureTransport, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, identity.Public(), identity)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string]bool)
+	for _, id := range allowedPeers {
+		allowed[strings.ToLower(strings.TrimSpace(id))] = true
+	}
+
+	return &SecureTransport{
+		identity: identity,
+		cert:     tls.Certificate{Certificate: [][]byte{der}, PrivateKey: identity},
+		allowed:  allowed,
+	}, nil
+}
+
+func (st *SecureTransport) Identity() string {
+	return IdentityOf(st.identity.Public().(ed25519.PublicKey))
+}
+
+// verifyPeer checks the certificate presented by the other side. The TLS
+// handshake already proves the peer holds the matching private key, so the
+// only thing left is to check the key type and the pinned identities.
+func (st *SecureTransport) verifyPeer(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("peer did not present an identity")
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("peer identity is not an ed25519 key")
+	}
+
+	if id := IdentityOf(pub); len(st.allowed) > 0 && !st.allowed[id] {
+		return fmt.Errorf("peer identity %s is not allowed", id)
+	}
+
+	return nil
+}
+
+func (st *SecureTransport) config() *tls.Config {
+	return &tls.Config{
+		MinVersion:            tls.VersionTLS13,
+		Certificates:          []tls.Certificate{st.cert},
+		ClientAuth:            tls.RequireAnyClientCert,
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: st.verifyPeer,
+	}
+}
+
+func (st *SecureTransport) Listen(addr string) (net.Listener, error) {
+	return tls.Listen(protocol, addr, st.config())
+}
+
+func (st *SecureTransport) Dial(addr string) (net.Conn, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, protocol, addr, st.config())
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// PeerIdentity returns the identity of the remote side of a secure connection
+// or an empty string for plaintext connections.
+func PeerIdentity(conn net.Conn) string {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	if pub, ok := state.PeerCertificates[0].PublicKey.(ed25519.PublicKey); ok {
+		return IdentityOf(pub)
+	}
+
+	return ""
+}
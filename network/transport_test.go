@@ -0,0 +1,107 @@
+package network_test
+
+import (
+	"crypto/ed25519"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/FG420/go-block/network"
+)
+
+func newSecureTransport(t *testing.T, name string, allowed ...string) (*network.SecureTransport, string) {
+	t.Helper()
+
+	key, err := network.LoadIdentity(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := network.NewSecureTransport(key, allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return st, network.IdentityOf(key.Public().(ed25519.PublicKey))
+}
+
+func exchange(t *testing.T, server, client network.Transport) (string, error) {
+	t.Helper()
+
+	ln, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		data string
+		peer string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		data, err := io.ReadAll(conn)
+		done <- result{string(data), network.PeerIdentity(conn), err}
+	}()
+
+	conn, err := client.Dial(ln.Addr().String())
+	if err == nil {
+		conn.Write([]byte("version"))
+		conn.Close()
+	}
+
+	res := <-done
+	if res.err != nil {
+		return "", res.err
+	}
+	if res.data != "version" {
+		t.Fatalf("received %q", res.data)
+	}
+
+	return res.peer, nil
+}
+
+func TestSecureTransport(t *testing.T) {
+	server, _ := newSecureTransport(t, "server")
+	client, clientID := newSecureTransport(t, "client")
+
+	peer, err := exchange(t, server, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer != clientID {
+		t.Fatalf("peer identity %s, want %s", peer, clientID)
+	}
+}
+
+func TestSecureTransportPinnedPeers(t *testing.T) {
+	_, otherID := newSecureTransport(t, "other")
+	server, _ := newSecureTransport(t, "server", otherID)
+	client, _ := newSecureTransport(t, "client")
+
+	if _, err := exchange(t, server, client); err == nil {
+		t.Fatal("expected unpinned peer to be rejected")
+	}
+}
+
+func TestLoadIdentityIsStable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodekey")
+
+	first, err := network.LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := network.LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Equal(second) {
+		t.Fatal("identity changed between loads")
+	}
+}
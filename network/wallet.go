@@ -0,0 +1,90 @@
+package network
+
+import (
+	"context"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/events"
+	"github.com/FG420/go-block/wallet"
+)
+
+// DefaultRebroadcastBlocks is how many blocks a wallet transaction may stay
+// unconfirmed before it is broadcast again.
+const DefaultRebroadcastBlocks = 3
+
+// WatchWallet keeps the pending transactions of the node's wallet up to date
+// as blocks arrive, until ctx is cancelled.
+func WatchWallet(ctx context.Context, chain *blockchain.BlockChain, nodeId string, blocks int) {
+	if blocks <= 0 {
+		blocks = DefaultRebroadcastBlocks
+	}
+
+	sub := events.DefaultBus.Subscribe(events.Filter{Types: []string{events.BlockConnected}})
+	defer sub.Close()
+
+	syncWallet(chain, nodeId, blocks)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.C:
+			syncWallet(chain, nodeId, blocks)
+		}
+	}
+}
+
+// syncWallet forgets pending transactions that confirmed or were
+// double-spent, and rebroadcasts those unconfirmed for blocks blocks. Peers
+// are only contacted once the wallet file is unlocked again.
+func syncWallet(chain *blockchain.BlockChain, nodeId string, blocks int) {
+	if ws, err := wallet.CreateWallets(nodeId); err != nil || len(ws.Pending) == 0 {
+		return
+	}
+
+	due := make(map[string]*wallet.PendingTx)
+	err := wallet.Update(nodeId, func(ws *wallet.Wallets) error {
+		utxoSet := blockchain.UTXOSet{BlockChain: chain}
+		confirmed, dropped := utxoSet.ResolvePending(ws)
+		for _, id := range confirmed {
+			walletLog.Info("wallet transaction confirmed", "txid", id)
+		}
+		for _, id := range dropped {
+			walletLog.Warn("wallet transaction conflicted, inputs unlocked", "txid", id)
+		}
+
+		best := chain.GetBestHeight()
+		for id, p := range ws.Pending {
+			if best-p.Broadcast < blocks {
+				continue
+			}
+			p.Broadcast = best
+			due[id] = p
+		}
+		return nil
+	})
+	if err != nil {
+		walletLog.Error("could not update pending wallet transactions", "err", err)
+		return
+	}
+
+	for id, p := range due {
+		rebroadcast(chain, id, p)
+	}
+}
+
+// rebroadcast puts a pending transaction back into the mempool if it fell
+// out, and announces it to every peer again.
+func rebroadcast(chain *blockchain.BlockChain, id string, p *wallet.PendingTx) {
+	tx := blockchain.DeserializeTransaction(p.Raw)
+	if !memoryPool.Has(tx.ID) {
+		if err := AcceptTx(chain, &tx); err != nil {
+			walletLog.Warn("could not rebroadcast wallet transaction", "txid", id, "err", err)
+			return
+		}
+	}
+
+	for _, node := range Nodes() {
+		SendInv(node, "tx", [][]byte{tx.ID})
+	}
+	walletLog.Info("rebroadcast wallet transaction", "txid", id, "sent", p.Height)
+}
@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnauthorized is returned when the daemon rejects the credentials.
+var ErrUnauthorized = errors.New("authentication failed, check RPC_USER and RPC_PASSWORD or the cookie file")
+
+type Client struct {
+	addr     string
+	user     string
+	password string
+	http     *http.Client
+	id       atomic.Int64
+}
+
+func NewClient(addr, user, password string) *Client {
+	return &Client{
+		addr:     addr,
+		user:     user,
+		password: password,
+		http:     &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// Detect returns a client for the node's running daemon, or nil if there is
+// none. RPC_ADDR, RPC_USER and RPC_PASSWORD configure the connection
+// explicitly; otherwise the cookie written by a local daemon is used. A
+// daemon that can't be talked to, such as one rejecting the credentials, is
+// an error rather than no daemon.
+func Detect(nodeId string) (*Client, error) {
+	addr := os.Getenv("RPC_ADDR")
+	user, password := os.Getenv("RPC_USER"), os.Getenv("RPC_PASSWORD")
+
+	if user == "" {
+		cookie, err := os.ReadFile(CookiePath(nodeId))
+		if err != nil {
+			if addr == "" {
+				return nil, nil
+			}
+		} else {
+			user, password, _ = strings.Cut(strings.TrimSpace(string(cookie)), ":")
+		}
+	}
+	if addr == "" {
+		addr = DefaultAddr(nodeId)
+	}
+
+	client := NewClient(addr, user, password)
+	var height int
+	if err := client.Call("getblockcount", &height); err != nil {
+		var rpcErr *Error
+		var opErr *net.OpError
+		switch {
+		case errors.As(err, &rpcErr):
+		case errors.As(err, &opErr) && opErr.Op == "dial":
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("node at %s: %w", addr, err)
+		}
+	}
+
+	return client, nil
+}
+
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Call invokes method with positional params and decodes the result into
+// result, which may be nil.
+func (c *Client) Call(method string, result any, params ...any) error {
+	if params == nil {
+		params = []any{}
+	}
+
+	id := c.id.Add(1)
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+c.addr, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.user, c.password)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("rpc %s: %w", method, ErrUnauthorized)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc %s: %s", method, res.Status)
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(resp.Result, result)
+}
@@ -0,0 +1,115 @@
+package rpc_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FG420/go-block/rpc"
+)
+
+// daemon serves handle behind basic auth as user:password and points the
+// client environment at it.
+func daemon(t *testing.T, user, password string, handle func(req rpc.Request) rpc.Response) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req rpc.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		resp := handle(req)
+		resp.JSONRPC, resp.ID = "2.0", req.ID
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("RPC_ADDR", strings.TrimPrefix(srv.URL, "http://"))
+	t.Setenv("RPC_USER", "alice")
+	t.Setenv("RPC_PASSWORD", "secret")
+}
+
+func height(req rpc.Request) rpc.Response {
+	if req.Method != "getblockcount" {
+		return rpc.Response{Error: &rpc.Error{Code: rpc.ErrMethodNotFound, Message: "method not found"}}
+	}
+	return rpc.Response{Result: 7}
+}
+
+func TestDetect(t *testing.T) {
+	daemon(t, "alice", "secret", height)
+
+	client, err := rpc.Detect("client-test")
+	if err != nil || client == nil {
+		t.Fatalf("client %v err %v", client, err)
+	}
+
+	var n int
+	if err := client.Call("getblockcount", &n); err != nil || n != 7 {
+		t.Fatalf("height %d err %v", n, err)
+	}
+
+	var rpcErr *rpc.Error
+	if err := client.Call("nope", nil); !errors.As(err, &rpcErr) || rpcErr.Code != rpc.ErrMethodNotFound {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestDetectRPCError(t *testing.T) {
+	// A daemon that answers with an error is still a daemon.
+	daemon(t, "alice", "secret", func(rpc.Request) rpc.Response {
+		return rpc.Response{Error: &rpc.Error{Code: rpc.ErrInternal, Message: "busy"}}
+	})
+
+	if client, err := rpc.Detect("client-test"); err != nil || client == nil {
+		t.Fatalf("client %v err %v", client, err)
+	}
+}
+
+func TestDetectUnauthorized(t *testing.T) {
+	daemon(t, "alice", "other", height)
+
+	client, err := rpc.Detect("client-test")
+	if !errors.Is(err, rpc.ErrUnauthorized) || client != nil {
+		t.Fatalf("client %v err %v", client, err)
+	}
+}
+
+func TestDetectNoDaemon(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	t.Setenv("RPC_ADDR", addr)
+	t.Setenv("RPC_USER", "alice")
+	if client, err := rpc.Detect("client-test"); err != nil || client != nil {
+		t.Fatalf("client %v err %v", client, err)
+	}
+
+	// Without an address or a cookie there is nothing to look for.
+	t.Setenv("RPC_ADDR", "")
+	t.Setenv("RPC_USER", "")
+	if client, err := rpc.Detect("client-test"); err != nil || client != nil {
+		t.Fatalf("client %v err %v", client, err)
+	}
+}
+
+func TestDetectNotADaemon(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	t.Setenv("RPC_ADDR", strings.TrimPrefix(srv.URL, "http://"))
+	t.Setenv("RPC_USER", "alice")
+	if client, err := rpc.Detect("client-test"); err == nil || client != nil {
+		t.Fatalf("client %v err %v", client, err)
+	}
+}
@@ -0,0 +1,3 @@
+package rpc
+
+var WalletError = walletError
@@ -0,0 +1,577 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/network"
+	"github.com/FG420/go-block/wallet"
+)
+
+type (
+	InputResult struct {
+		TxID      string `json:"txid"`
+		Vout      int    `json:"vout"`
+		Signature string `json:"signature"`
+		PubKey    string `json:"pubkey"`
+	}
+
+	OutputResult struct {
+		Value      int    `json:"value"`
+		PubKeyHash string `json:"pubkeyhash"`
+		Address    string `json:"address"`
+	}
+
+	TxResult struct {
+		TxID          string         `json:"txid"`
+		Coinbase      bool           `json:"coinbase"`
+		Inputs        []InputResult  `json:"vin"`
+		Outputs       []OutputResult `json:"vout"`
+		BlockHash     string         `json:"blockhash,omitempty"`
+		Confirmations int            `json:"confirmations"`
+		Hex           string         `json:"hex"`
+	}
+
+	BlockResult struct {
+		Hash          string     `json:"hash"`
+		PrevHash      string     `json:"previousblockhash"`
+		Height        int        `json:"height"`
+		Nonce         int        `json:"nonce"`
+		Timestamp     int64      `json:"time"`
+		Confirmations int        `json:"confirmations"`
+		Transactions  []TxResult `json:"tx"`
+	}
+
+	HistoryResult struct {
+		TxID          string `json:"txid"`
+		BlockHash     string `json:"blockhash"`
+		Height        int    `json:"height"`
+		Confirmations int    `json:"confirmations"`
+		Received      int    `json:"received"`
+		Sent          int    `json:"sent"`
+	}
+
+	MempoolInfo struct {
+		Size  int `json:"size"`
+		Bytes int `json:"bytes"`
+	}
+
+	PeerResult struct {
+		Addr        string `json:"addr"`
+		Connected   bool   `json:"connected"`
+		LastSeen    int64  `json:"lastseen"`
+		LastAttempt int64  `json:"lastattempt"`
+		Successes   int    `json:"successes"`
+		Failures    int    `json:"failures"`
+	}
+)
+
+var methods = map[string]handlerFunc{
+	"getblockcount":        getBlockCount,
+	"getbestblockhash":     getBestBlockHash,
+	"getblock":             getBlock,
+	"gettransaction":       getTransaction,
+	"getbalance":           getBalance,
+	"getaddresshistory":    getAddressHistory,
+	"getwalletbalance":     getWalletBalance,
+	"listunspent":          listUnspent,
+	"createrawtransaction": createRawTransaction,
+	"sendrawtransaction":   sendRawTransaction,
+	"getmempoolinfo":       getMempoolInfo,
+	"getpeerinfo":          getPeerInfo,
+	"send":                 send,
+	"sendmany":             sendMany,
+	"encryptwallet":        encryptWallet,
+	"walletpassphrase":     walletPassphrase,
+	"walletlock":           walletLock,
+	"stop":                 stop,
+}
+
+func intParam(params []json.RawMessage, i int, name string) (int, *Error) {
+	if i >= len(params) {
+		return 0, errorf(ErrInvalidParams, "missing parameter %s", name)
+	}
+
+	var value int
+	if err := json.Unmarshal(params[i], &value); err != nil {
+		return 0, errorf(ErrInvalidParams, "%s must be an integer", name)
+	}
+
+	return value, nil
+}
+
+func boolParam(params []json.RawMessage, i int, name string) (bool, *Error) {
+	if i >= len(params) {
+		return false, nil
+	}
+
+	var value bool
+	if err := json.Unmarshal(params[i], &value); err != nil {
+		return false, errorf(ErrInvalidParams, "%s must be a boolean", name)
+	}
+
+	return value, nil
+}
+
+func stringParam(params []json.RawMessage, i int, name string) (string, *Error) {
+	if i >= len(params) {
+		return "", errorf(ErrInvalidParams, "missing parameter %s", name)
+	}
+
+	var value string
+	if err := json.Unmarshal(params[i], &value); err != nil {
+		return "", errorf(ErrInvalidParams, "%s must be a string", name)
+	}
+
+	return value, nil
+}
+
+// stringsParam reads an optional array of strings.
+func stringsParam(params []json.RawMessage, i int, name string) ([]string, *Error) {
+	if i >= len(params) || string(params[i]) == "null" {
+		return nil, nil
+	}
+
+	var value []string
+	if err := json.Unmarshal(params[i], &value); err != nil {
+		return nil, errorf(ErrInvalidParams, "%s must be an array of strings", name)
+	}
+
+	return value, nil
+}
+
+func hexParam(params []json.RawMessage, i int, name string) ([]byte, *Error) {
+	value, rpcErr := stringParam(params, i, name)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, errorf(ErrInvalidParams, "%s must be hex encoded", name)
+	}
+
+	return data, nil
+}
+
+func NewTxResult(tx *blockchain.Transaction) TxResult {
+	res := TxResult{
+		TxID:     hex.EncodeToString(tx.ID),
+		Coinbase: tx.IsCoinbase(),
+		Hex:      hex.EncodeToString(tx.Serialize()),
+	}
+
+	for _, in := range tx.Inputs {
+		res.Inputs = append(res.Inputs, InputResult{
+			TxID:      hex.EncodeToString(in.ID),
+			Vout:      in.Out,
+			Signature: hex.EncodeToString(in.Signature),
+			PubKey:    hex.EncodeToString(in.PubKey),
+		})
+	}
+
+	for _, out := range tx.Outputs {
+		res.Outputs = append(res.Outputs, OutputResult{
+			Value:      out.Value,
+			PubKeyHash: hex.EncodeToString(out.PubKeyHash),
+			Address:    string(wallet.HashToAddress(out.PubKeyHash)),
+		})
+	}
+
+	return res
+}
+
+func NewBlockResult(b *blockchain.Block, bestHeight int) BlockResult {
+	res := BlockResult{
+		Hash:          hex.EncodeToString(b.Hash),
+		PrevHash:      hex.EncodeToString(b.PrevHash),
+		Height:        b.Height,
+		Nonce:         b.Nonce,
+		Timestamp:     b.Timestamp,
+		Confirmations: bestHeight - b.Height + 1,
+	}
+
+	for _, tx := range b.Transactions {
+		res.Transactions = append(res.Transactions, NewTxResult(tx))
+	}
+
+	return res
+}
+
+func getBlockCount(s *Server, params []json.RawMessage) (any, *Error) {
+	return s.chain.GetBestHeight(), nil
+}
+
+func getBestBlockHash(s *Server, params []json.RawMessage) (any, *Error) {
+	return hex.EncodeToString(s.chain.LastHash), nil
+}
+
+func getBlock(s *Server, params []json.RawMessage) (any, *Error) {
+	hash, rpcErr := hexParam(params, 0, "hash")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	block, err := s.chain.GetBlock(hash)
+	if err != nil {
+		return nil, errorf(ErrNotFound, "block %x not found", hash)
+	}
+
+	return NewBlockResult(block, s.chain.GetBestHeight()), nil
+}
+
+func getTransaction(s *Server, params []json.RawMessage) (any, *Error) {
+	id, rpcErr := hexParam(params, 0, "txid")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	if tx, ok := network.TxPool().Get(id); ok {
+		return NewTxResult(&tx), nil
+	}
+
+	tx, block, err := s.chain.LocateTransaction(id)
+	if err != nil {
+		return nil, errorf(ErrNotFound, "transaction %x not found", id)
+	}
+
+	res := NewTxResult(tx)
+	res.BlockHash = hex.EncodeToString(block.Hash)
+	res.Confirmations = s.chain.GetBestHeight() - block.Height + 1
+
+	return res, nil
+}
+
+func getBalance(s *Server, params []json.RawMessage) (any, *Error) {
+	addr, rpcErr := stringParam(params, 0, "address")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if !wallet.ValidateAddress(addr) {
+		return nil, errorf(ErrInvalidParams, "invalid address %s", addr)
+	}
+
+	utxoSet := blockchain.UTXOSet{BlockChain: s.chain}
+	balance := 0
+	for _, out := range utxoSet.FindUTXO(wallet.AddressToHash(addr)) {
+		balance += out.Value
+	}
+
+	return balance, nil
+}
+
+// NewHistoryResults converts the history of an address for the API.
+func NewHistoryResults(history []blockchain.HistoryEntry, bestHeight int) []HistoryResult {
+	results := []HistoryResult{}
+	for _, entry := range history {
+		results = append(results, HistoryResult{
+			TxID:          hex.EncodeToString(entry.TxID),
+			BlockHash:     hex.EncodeToString(entry.BlockHash),
+			Height:        entry.Height,
+			Confirmations: bestHeight - entry.Height + 1,
+			Received:      entry.Received,
+			Sent:          entry.Sent,
+		})
+	}
+	return results
+}
+
+func getAddressHistory(s *Server, params []json.RawMessage) (any, *Error) {
+	addr, rpcErr := stringParam(params, 0, "address")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if !wallet.ValidateAddress(addr) {
+		return nil, errorf(ErrInvalidParams, "invalid address %s", addr)
+	}
+
+	history := s.chain.AddressHistory(wallet.AddressToHash(addr))
+	return NewHistoryResults(history, s.chain.GetBestHeight()), nil
+}
+
+func sendRawTransaction(s *Server, params []json.RawMessage) (any, *Error) {
+	data, rpcErr := hexParam(params, 0, "hex")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	tx, err := blockchain.DecodeTransaction(data)
+	if err != nil {
+		return nil, errorf(ErrInvalidParams, "%s", err)
+	}
+	height := s.chain.GetBestHeight()
+	if err := network.AcceptTx(s.chain, tx); err != nil {
+		return nil, errorf(ErrRejected, "%s", err)
+	}
+	s.trackRawTransaction(tx, height)
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+func getMempoolInfo(s *Server, params []json.RawMessage) (any, *Error) {
+	pool := network.TxPool()
+	return MempoolInfo{Size: pool.Count(), Bytes: pool.Bytes()}, nil
+}
+
+func getPeerInfo(s *Server, params []json.RawMessage) (any, *Error) {
+	peers := []PeerResult{}
+	for _, peer := range network.Peers() {
+		peers = append(peers, PeerResult{
+			Addr:        peer.Addr,
+			Connected:   peer.Connected,
+			LastSeen:    peer.LastSeen,
+			LastAttempt: peer.LastAttempt,
+			Successes:   peer.Successes,
+			Failures:    peer.Failures,
+		})
+	}
+
+	return peers, nil
+}
+
+// send builds and signs a transaction with a key from the node's wallet file.
+// With mine set the transaction is mined into a block right away, otherwise it
+// goes through the mempool like any relayed transaction. The optional
+// strategy and inputs choose the outputs it spends, see
+// blockchain.NewCoinSelector.
+func send(s *Server, params []json.RawMessage) (any, *Error) {
+	from, rpcErr := stringParam(params, 0, "from")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	to, rpcErr := stringParam(params, 1, "to")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	amount, rpcErr := intParam(params, 2, "amount")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	mine, rpcErr := boolParam(params, 3, "mine")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	var strategy string
+	if len(params) > 4 {
+		if strategy, rpcErr = stringParam(params, 4, "strategy"); rpcErr != nil {
+			return nil, rpcErr
+		}
+	}
+	inputs, rpcErr := stringsParam(params, 5, "inputs")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	if !wallet.ValidateAddress(from) || !wallet.ValidateAddress(to) {
+		return nil, errorf(ErrInvalidParams, "address is not valid")
+	}
+	if amount <= 0 {
+		return nil, errorf(ErrInvalidParams, "amount must be positive")
+	}
+	selector, rpcErr := coinSelector(strategy, inputs)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	return s.pay(from, []blockchain.Payment{{Address: to, Amount: amount}}, mine, selector)
+}
+
+// sendMany pays a list of {address, amount} objects in one transaction. The
+// remaining parameters are those of send.
+func sendMany(s *Server, params []json.RawMessage) (any, *Error) {
+	from, rpcErr := stringParam(params, 0, "from")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if len(params) < 2 {
+		return nil, errorf(ErrInvalidParams, "missing parameter payments")
+	}
+	var payments []blockchain.Payment
+	if err := json.Unmarshal(params[1], &payments); err != nil {
+		return nil, errorf(ErrInvalidParams, "payments must be an array of {address, amount} objects")
+	}
+	mine, rpcErr := boolParam(params, 2, "mine")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	var strategy string
+	if len(params) > 3 {
+		if strategy, rpcErr = stringParam(params, 3, "strategy"); rpcErr != nil {
+			return nil, rpcErr
+		}
+	}
+	inputs, rpcErr := stringsParam(params, 4, "inputs")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	if !wallet.ValidateAddress(from) {
+		return nil, errorf(ErrInvalidParams, "address is not valid")
+	}
+	if _, err := blockchain.ValidatePayments(payments); err != nil {
+		return nil, errorf(ErrInvalidParams, "%s", err)
+	}
+	selector, rpcErr := coinSelector(strategy, inputs)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	return s.pay(from, payments, mine, selector)
+}
+
+// pay builds the transaction and tracks it in the wallet until it confirms,
+// so concurrent sends never pick the same inputs. With mine it is mined
+// after the wallet file is released, and forgotten again if mining fails.
+func (s *Server) pay(from string, payments []blockchain.Payment, mine bool, selector blockchain.CoinSelector) (any, *Error) {
+	var tx *blockchain.Transaction
+	err := wallet.Update(s.cfg.NodeID, func(wallets *wallet.Wallets) error {
+		w := wallets.GetAddress(from)
+		if w == nil {
+			return errorf(ErrInvalidParams, "address %s is not in the wallet", from)
+		}
+		if w.WatchOnly() {
+			return errorf(ErrInvalidParams, "address %s is watch-only", from)
+		}
+		if w.Locked() {
+			return errorf(ErrWalletLocked, "wallet is locked, unlock it with walletpassphrase first")
+		}
+
+		change, err := wallets.ChangeAddress()
+		if err != nil {
+			return walletError(err)
+		}
+
+		utxoSet := blockchain.UTXOSet{BlockChain: s.chain}
+		utxoSet.ResolvePending(wallets)
+		tx, err = blockchain.NewPaymentTransaction(w, payments, change, &utxoSet,
+			blockchain.SkipLocked(selector, wallets.LockedInputs()))
+		if err != nil {
+			return walletError(err)
+		}
+		if len(tx.Outputs) == len(payments) {
+			wallets.ReleaseChange(change)
+		}
+
+		height := s.chain.GetBestHeight()
+		if !mine {
+			if err := network.AcceptTx(s.chain, tx); err != nil {
+				return errorf(ErrRejected, "%s", err)
+			}
+		}
+		wallets.AddPending(hex.EncodeToString(tx.ID), blockchain.NewPendingTx(tx, height))
+		return nil
+	})
+
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return nil, rpcErr
+	}
+	if err != nil {
+		return nil, errorf(ErrInternal, "%s", err)
+	}
+
+	txID := hex.EncodeToString(tx.ID)
+	if mine {
+		cbTx := blockchain.CoinbaseTx(from, "")
+		if _, err := network.MineTransactions(s.ctx, s.chain, []*blockchain.Transaction{cbTx, tx}); err != nil {
+			wallet.Update(s.cfg.NodeID, func(wallets *wallet.Wallets) error {
+				wallets.RemovePending(txID)
+				return nil
+			})
+			return nil, errorf(ErrInternal, "%s", err)
+		}
+	}
+	return txID, nil
+}
+
+func coinSelector(strategy string, inputs []string) (blockchain.CoinSelector, *Error) {
+	var outpoints []blockchain.Outpoint
+	for _, in := range inputs {
+		outpoint, err := blockchain.ParseOutpoint(in)
+		if err != nil {
+			return nil, errorf(ErrInvalidParams, "%s", err)
+		}
+		outpoints = append(outpoints, outpoint)
+	}
+
+	selector, err := blockchain.NewCoinSelector(strategy, outpoints)
+	if err != nil {
+		return nil, errorf(ErrInvalidParams, "%s", err)
+	}
+	return selector, nil
+}
+
+// walletError maps wallet errors to their RPC error codes.
+func walletError(err error) *Error {
+	switch {
+	case errors.Is(err, wallet.ErrWrongPassphrase):
+		return errorf(ErrPassphrase, "%s", err)
+	case errors.Is(err, wallet.ErrNotEncrypted), errors.Is(err, wallet.ErrAlreadyEncrypted):
+		return errorf(ErrWalletState, "%s", err)
+	case errors.Is(err, wallet.ErrLocked):
+		return errorf(ErrWalletLocked, "%s", err)
+	case errors.Is(err, blockchain.ErrInsufficientFunds), errors.Is(err, blockchain.ErrNoExactMatch):
+		return errorf(ErrFunds, "%s", err)
+	case errors.Is(err, blockchain.ErrUnknownInput), errors.Is(err, blockchain.ErrDuplicateInput),
+		errors.Is(err, blockchain.ErrInvalidPayment), errors.Is(err, blockchain.ErrLockedInput),
+		errors.Is(err, wallet.ErrWatchOnly):
+		return errorf(ErrInvalidParams, "%s", err)
+	}
+	return errorf(ErrInternal, "%s", err)
+}
+
+func encryptWallet(s *Server, params []json.RawMessage) (any, *Error) {
+	passphrase, rpcErr := stringParam(params, 0, "passphrase")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if passphrase == "" {
+		return nil, errorf(ErrInvalidParams, "passphrase must not be empty")
+	}
+
+	err := wallet.Update(s.cfg.NodeID, func(wallets *wallet.Wallets) error {
+		if err := wallets.Encrypt(passphrase); err != nil {
+			return err
+		}
+		wallets.Lock()
+		return nil
+	})
+	if err != nil {
+		return nil, walletError(err)
+	}
+
+	return "wallet encrypted, unlock it with walletpassphrase to spend", nil
+}
+
+func walletPassphrase(s *Server, params []json.RawMessage) (any, *Error) {
+	passphrase, rpcErr := stringParam(params, 0, "passphrase")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	timeout, rpcErr := intParam(params, 1, "timeout")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if timeout <= 0 {
+		return nil, errorf(ErrInvalidParams, "timeout must be positive")
+	}
+
+	if err := wallet.UnlockFor(s.cfg.NodeID, passphrase, time.Duration(timeout)*time.Second); err != nil {
+		return nil, walletError(err)
+	}
+	return nil, nil
+}
+
+func walletLock(s *Server, params []json.RawMessage) (any, *Error) {
+	wallet.LockFor(s.cfg.NodeID)
+	return nil, nil
+}
+
+func stop(s *Server, params []json.RawMessage) (any, *Error) {
+	network.Stop()
+	return fmt.Sprintf("node %s stopping", s.cfg.NodeID), nil
+}
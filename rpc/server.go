@@ -0,0 +1,287 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/logging"
+)
+
+const (
+	cookieFile     = "./tmp/rpc_%s.cookie"
+	cookieUser     = "__cookie__"
+	maxRequestSize = 8 << 20
+)
+
+var rpcLog = logging.Get(logging.RPC)
+
+// JSON-RPC 2.0 error codes.
+const (
+	ErrParse          = -32700
+	ErrInvalidRequest = -32600
+	ErrMethodNotFound = -32601
+	ErrInvalidParams  = -32602
+	ErrInternal       = -32603
+	ErrNotFound       = -5
+	ErrFunds          = -6
+	ErrWalletLocked   = -13
+	ErrPassphrase     = -14
+	ErrWalletState    = -15
+	ErrRejected       = -26
+)
+
+type (
+	Request struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params,omitempty"`
+		ID      json.RawMessage `json:"id,omitempty"`
+	}
+
+	Response struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  any             `json:"result"`
+		Error   *Error          `json:"error,omitempty"`
+		ID      json.RawMessage `json:"id"`
+	}
+
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	handlerFunc func(s *Server, params []json.RawMessage) (any, *Error)
+
+	Config struct {
+		NodeID   string
+		Addr     string
+		User     string
+		Password string
+	}
+
+	Server struct {
+		chain      *blockchain.BlockChain
+		cfg        Config
+		cookie     string
+		cookiePath string
+		http       *http.Server
+
+		// ctx is cancelled by Shutdown, stopping long calls such as mining.
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func errorf(code int, format string, args ...any) *Error {
+	return &Error{code, fmt.Sprintf(format, args...)}
+}
+
+// DefaultAddr is the loopback address the RPC server of a node listens on
+// when none is configured: the node's port shifted by 1000.
+func DefaultAddr(nodeId string) string {
+	port, err := strconv.Atoi(nodeId)
+	if err != nil {
+		return "127.0.0.1:8332"
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1000))
+}
+
+func CookiePath(nodeId string) string {
+	return fmt.Sprintf(cookieFile, nodeId)
+}
+
+func NewServer(chain *blockchain.BlockChain, cfg Config) *Server {
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultAddr(cfg.NodeID)
+	}
+
+	s := &Server{chain: chain, cfg: cfg, cookiePath: CookiePath(cfg.NodeID)}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.http = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+// ListenAndServe writes a fresh auth cookie and serves until Shutdown.
+func (s *Server) ListenAndServe() error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	s.cookie = hex.EncodeToString(secret)
+	if err := os.WriteFile(s.cookiePath, []byte(cookieUser+":"+s.cookie), 0600); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		os.Remove(s.cookiePath)
+		return err
+	}
+	rpcLog.Info("RPC server listening", "addr", ln.Addr().String())
+
+	err = s.http.Serve(ln)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	defer os.Remove(s.cookiePath)
+	s.cancel()
+	return s.http.Shutdown(ctx)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	if s.cookie != "" && user == cookieUser &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(s.cookie)) == 1 {
+		return true
+	}
+
+	return s.cfg.User != "" &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(s.cfg.User)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(s.cfg.Password)) == 1
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result any
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			result = &Response{JSONRPC: "2.0", Error: errorf(ErrParse, "invalid batch"), ID: json.RawMessage("null")}
+		} else {
+			var responses []*Response
+			for _, raw := range batch {
+				if resp := s.handle(raw); resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			if len(responses) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			result = responses
+		}
+	} else {
+		resp := s.handle(body)
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		result = resp
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// MarshalJSON writes exactly one of result and error, as JSON-RPC 2.0
+// requires: a success always has a result, null if the call returned none.
+func (r Response) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *Error          `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{r.JSONRPC, r.Error, r.ID})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  any             `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{r.JSONRPC, r.Result, r.ID})
+}
+
+// handle runs a single request. Notifications, requests without an id, get no
+// response.
+func (s *Server) handle(raw json.RawMessage) (resp *Response) {
+	var req Request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return &Response{JSONRPC: "2.0", Error: errorf(ErrParse, "parse error"), ID: json.RawMessage("null")}
+	}
+
+	resp = &Response{JSONRPC: "2.0", ID: req.ID}
+	if len(req.ID) == 0 {
+		resp.ID = json.RawMessage("null")
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = errorf(ErrInvalidRequest, "invalid request")
+		return resp
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			rpcLog.Error("call panicked", "method", req.Method, "err", r)
+			resp.Result = nil
+			resp.Error = errorf(ErrInternal, "%v", r)
+		}
+		if len(req.ID) == 0 {
+			resp = nil
+		}
+	}()
+
+	handler, ok := methods[req.Method]
+	if !ok {
+		resp.Error = errorf(ErrMethodNotFound, "method %q not found", req.Method)
+		return resp
+	}
+
+	var params []json.RawMessage
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			resp.Error = errorf(ErrInvalidParams, "params must be an array")
+			return resp
+		}
+	}
+
+	resp.Result, resp.Error = handler(s, params)
+	if resp.Error != nil {
+		rpcLog.Debug("call failed", "method", req.Method, "code", resp.Error.Code, "err", resp.Error.Message)
+	} else {
+		rpcLog.Debug("call", "method", req.Method)
+	}
+	return resp
+}
@@ -0,0 +1,387 @@
+package rpc_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/network"
+	"github.com/FG420/go-block/rpc"
+	"github.com/FG420/go-block/wallet"
+)
+
+// TestMain runs the tests in a scratch directory, as the node keeps its
+// chain and wallet under ./tmp, without peers to announce to.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "rpc-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.Mkdir("tmp", 0755); err != nil {
+		panic(err)
+	}
+	network.KnownNodes = nil
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type testNode struct {
+	nodeId string
+	chain  *blockchain.BlockChain
+	url    string
+	client *rpc.Client
+	addr   string
+}
+
+var nodes int
+
+// newNode starts an RPC server on a fresh chain whose genesis block pays
+// the only address of the node's wallet.
+func newNode(t *testing.T) *testNode {
+	nodes++
+	nodeId := fmt.Sprintf("rpc%d", nodes)
+
+	ws, err := wallet.CreateWallets(nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SaveFile(nodeId)
+
+	chain, err := blockchain.InitBlockChain(addr, nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(chain.Close)
+	utxoSet := blockchain.UTXOSet{BlockChain: chain}
+	utxoSet.Reindex()
+
+	srv := httptest.NewServer(rpc.NewServer(chain, rpc.Config{NodeID: nodeId, User: "alice", Password: "secret"}))
+	t.Cleanup(srv.Close)
+
+	return &testNode{
+		nodeId: nodeId,
+		chain:  chain,
+		url:    srv.URL,
+		client: rpc.NewClient(strings.TrimPrefix(srv.URL, "http://"), "alice", "secret"),
+		addr:   addr,
+	}
+}
+
+// post sends body as alice and returns the status and response body.
+func (n *testNode) post(t *testing.T, body string) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, n.url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(data)
+}
+
+func code(err error) int {
+	var rpcErr *rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return 0
+	}
+	return rpcErr.Code
+}
+
+func TestAuth(t *testing.T) {
+	node := newNode(t)
+	body := `{"jsonrpc":"2.0","id":1,"method":"getblockcount"}`
+
+	tests := []struct {
+		name       string
+		method     string
+		user, pass string
+		status     int
+	}{
+		{"no credentials", http.MethodPost, "", "", http.StatusUnauthorized},
+		{"wrong password", http.MethodPost, "alice", "guess", http.StatusUnauthorized},
+		{"cookie user without cookie", http.MethodPost, "__cookie__", "", http.StatusUnauthorized},
+		{"get", http.MethodGet, "alice", "secret", http.StatusMethodNotAllowed},
+		{"ok", http.MethodPost, "alice", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, node.url, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", res.StatusCode, tt.status)
+			}
+		})
+	}
+
+	bad := rpc.NewClient(strings.TrimPrefix(node.url, "http://"), "alice", "guess")
+	if err := bad.Call("getblockcount", nil); !errors.Is(err, rpc.ErrUnauthorized) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	node := newNode(t)
+
+	status, body := node.post(t, `[
+		{"jsonrpc":"2.0","id":1,"method":"getblockcount"},
+		{"jsonrpc":"2.0","method":"getblockcount"},
+		{"jsonrpc":"2.0","id":"two","method":"nope"},
+		{"jsonrpc":"1.0","id":3,"method":"getblockcount"}
+	]`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	var responses []struct {
+		ID     json.RawMessage `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *rpc.Error      `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &responses); err != nil {
+		t.Fatal(err)
+	}
+
+	// The notification gets no response, the others keep their order.
+	if len(responses) != 3 {
+		t.Fatalf("got %d responses: %s", len(responses), body)
+	}
+	if string(responses[0].ID) != "1" || string(responses[0].Result) != "0" || responses[0].Error != nil {
+		t.Fatalf("response 1: %s", body)
+	}
+	if string(responses[1].ID) != `"two"` || responses[1].Error == nil || responses[1].Error.Code != rpc.ErrMethodNotFound {
+		t.Fatalf("response 2: %s", body)
+	}
+	if string(responses[2].ID) != "3" || responses[2].Error == nil || responses[2].Error.Code != rpc.ErrInvalidRequest {
+		t.Fatalf("response 3: %s", body)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   int
+	}{
+		{"notifications only", `[{"jsonrpc":"2.0","method":"getblockcount"}]`, http.StatusNoContent, 0},
+		{"notification", `{"jsonrpc":"2.0","method":"getblockcount"}`, http.StatusNoContent, 0},
+		{"empty batch", `[]`, http.StatusOK, rpc.ErrParse},
+		{"broken batch", `[{"jsonrpc":`, http.StatusOK, rpc.ErrParse},
+		{"params not an array", `{"jsonrpc":"2.0","id":1,"method":"getblock","params":{"hash":"00"}}`, http.StatusOK, rpc.ErrInvalidParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := node.post(t, tt.body)
+			if status != tt.status {
+				t.Fatalf("status %d, want %d", status, tt.status)
+			}
+			if tt.code == 0 {
+				if body != "" {
+					t.Fatalf("unexpected body %s", body)
+				}
+				return
+			}
+			var resp rpc.Response
+			if err := json.Unmarshal([]byte(body), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error == nil || resp.Error.Code != tt.code {
+				t.Fatalf("got %s", body)
+			}
+		})
+	}
+}
+
+// Every response carries either a result, null for calls that return none,
+// or an error.
+func TestResultMember(t *testing.T) {
+	node := newNode(t)
+
+	tests := []struct {
+		body, result string
+		error        bool
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"walletlock"}`, "null", false},
+		{`{"jsonrpc":"2.0","id":1,"method":"getblockcount"}`, "0", false},
+		{`{"jsonrpc":"2.0","id":1,"method":"nope"}`, "", true},
+	}
+	for _, tt := range tests {
+		_, body := node.post(t, tt.body)
+		var resp map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatal(err)
+		}
+		result, hasResult := resp["result"]
+		_, hasError := resp["error"]
+		if hasError != tt.error || hasResult == tt.error || string(result) != tt.result {
+			t.Errorf("%s: got %s", tt.body, body)
+		}
+	}
+}
+
+func TestWalletError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{wallet.ErrWrongPassphrase, rpc.ErrPassphrase},
+		{wallet.ErrNotEncrypted, rpc.ErrWalletState},
+		{wallet.ErrAlreadyEncrypted, rpc.ErrWalletState},
+		{wallet.ErrLocked, rpc.ErrWalletLocked},
+		{blockchain.ErrInsufficientFunds, rpc.ErrFunds},
+		{blockchain.ErrNoExactMatch, rpc.ErrFunds},
+		{blockchain.ErrUnknownInput, rpc.ErrInvalidParams},
+		{blockchain.ErrDuplicateInput, rpc.ErrInvalidParams},
+		{blockchain.ErrInvalidPayment, rpc.ErrInvalidParams},
+		{blockchain.ErrLockedInput, rpc.ErrInvalidParams},
+		{wallet.ErrWatchOnly, rpc.ErrInvalidParams},
+		{errors.New("disk full"), rpc.ErrInternal},
+	}
+	for _, tt := range tests {
+		// Callers wrap the sentinel errors with context.
+		err := fmt.Errorf("paying: %w", tt.err)
+		if got := rpc.WalletError(err); got.Code != tt.code || !strings.Contains(got.Message, tt.err.Error()) {
+			t.Errorf("%v: got %v, want code %d", tt.err, got, tt.code)
+		}
+	}
+}
+
+func TestPay(t *testing.T) {
+	node := newNode(t)
+	to := string(wallet.MakeWallet().Address())
+
+	var before rpc.MempoolInfo
+	if err := node.client.Call("getmempoolinfo", &before); err != nil {
+		t.Fatal(err)
+	}
+
+	var txID string
+	if err := node.client.Call("send", &txID, node.addr, to, 30, false); err != nil {
+		t.Fatal(err)
+	}
+	var info rpc.MempoolInfo
+	if err := node.client.Call("getmempoolinfo", &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != before.Size+1 {
+		t.Fatalf("mempool has %d txs, want %d", info.Size, before.Size+1)
+	}
+
+	// The spend is tracked until it confirms, locking the genesis output.
+	var balance rpc.WalletBalanceResult
+	if err := node.client.Call("getwalletbalance", &balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance.Pending != 1 || balance.Confirmed != 100 || balance.Available != 0 || balance.Unconfirmed != -30 {
+		t.Fatalf("balance %+v", balance)
+	}
+	if err := node.client.Call("send", nil, node.addr, to, 10, false); code(err) != rpc.ErrFunds {
+		t.Fatalf("spent a locked output: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		params []any
+		code   int
+	}{
+		{"not in wallet", []any{to, node.addr, 10, false}, rpc.ErrInvalidParams},
+		{"invalid address", []any{node.addr, "nope", 10, false}, rpc.ErrInvalidParams},
+		{"zero amount", []any{node.addr, to, 0, false}, rpc.ErrInvalidParams},
+		{"unknown strategy", []any{node.addr, to, 10, false, "cheapest"}, rpc.ErrInvalidParams},
+	}
+	for _, tt := range tests {
+		if err := node.client.Call("send", nil, tt.params...); code(err) != tt.code {
+			t.Errorf("%s: got %v, want code %d", tt.name, err, tt.code)
+		}
+	}
+}
+
+func TestPayMine(t *testing.T) {
+	node := newNode(t)
+	to := string(wallet.MakeWallet().Address())
+
+	var txID string
+	if err := node.client.Call("send", &txID, node.addr, to, 30, true); err != nil {
+		t.Fatal(err)
+	}
+	var height int
+	if err := node.client.Call("getblockcount", &height); err != nil || height != 1 {
+		t.Fatalf("height %d err %v", height, err)
+	}
+
+	var balance rpc.WalletBalanceResult
+	if err := node.client.Call("getwalletbalance", &balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance.Pending != 0 || balance.Confirmed != 170 || balance.Unconfirmed != 0 {
+		t.Fatalf("balance %+v", balance)
+	}
+}
+
+func TestPayLocked(t *testing.T) {
+	node := newNode(t)
+	to := string(wallet.MakeWallet().Address())
+
+	if err := node.client.Call("encryptwallet", nil, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.client.Call("encryptwallet", nil, "hunter2"); code(err) != rpc.ErrWalletState {
+		t.Fatalf("encrypted twice: %v", err)
+	}
+	if err := node.client.Call("send", nil, node.addr, to, 10, false); code(err) != rpc.ErrWalletLocked {
+		t.Fatalf("sent from a locked wallet: %v", err)
+	}
+	if err := node.client.Call("walletpassphrase", nil, "guess", 60); code(err) != rpc.ErrPassphrase {
+		t.Fatalf("unlocked with a wrong passphrase: %v", err)
+	}
+	if err := node.client.Call("walletpassphrase", nil, "hunter2", 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.client.Call("send", nil, node.addr, to, 10, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.client.Call("walletlock", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Bytes that aren't a transaction are the caller's mistake, not a crash.
+func TestSendRawTransactionInvalid(t *testing.T) {
+	node := newNode(t)
+
+	for _, data := range []string{"zz", "", "00", "deadbeef"} {
+		if err := node.client.Call("sendrawtransaction", nil, data); code(err) != rpc.ErrInvalidParams {
+			t.Errorf("%q: got %v, want code %d", data, err, rpc.ErrInvalidParams)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/network"
+	"github.com/FG420/go-block/wallet"
+)
+
+type (
+	// WalletBalanceResult sums every address of the wallet. Unconfirmed is
+	// the net effect of mempool and pending wallet transactions and is
+	// negative while a spend is pending. Available leaves out the outputs
+	// locked by pending transactions. Watch-only addresses are counted
+	// separately.
+	WalletBalanceResult struct {
+		Confirmed            int `json:"confirmed"`
+		Unconfirmed          int `json:"unconfirmed"`
+		Available            int `json:"available"`
+		Pending              int `json:"pending"`
+		WatchOnlyConfirmed   int `json:"watchonly_confirmed"`
+		WatchOnlyUnconfirmed int `json:"watchonly_unconfirmed"`
+	}
+
+	UnspentResult struct {
+		TxID          string `json:"txid"`
+		Vout          int    `json:"vout"`
+		Amount        int    `json:"amount"`
+		Address       string `json:"address"`
+		Label         string `json:"label,omitempty"`
+		Confirmations int    `json:"confirmations"`
+		WatchOnly     bool   `json:"watchonly,omitempty"`
+		Locked        bool   `json:"locked,omitempty"`
+	}
+)
+
+// walletHashes maps the hex public key hash of every wallet address to the
+// address.
+func walletHashes(wallets *wallet.Wallets) map[string]string {
+	hashes := make(map[string]string, len(wallets.Wallets))
+	for addr, w := range wallets.Wallets {
+		hashes[hex.EncodeToString(w.KeyHash())] = addr
+	}
+	return hashes
+}
+
+func outpoint(txID string, vout int) string {
+	return fmt.Sprintf("%s:%d", txID, vout)
+}
+
+// NewUnspentResults lists the confirmed outputs of the wallet, oldest first.
+// Pending transactions that are no longer pending are dropped from wallets
+// first, so their inputs don't show up as locked.
+func NewUnspentResults(chain *blockchain.BlockChain, wallets *wallet.Wallets) []UnspentResult {
+	hashes := walletHashes(wallets)
+	lookup := make(map[string]bool, len(hashes))
+	for hash := range hashes {
+		lookup[hash] = true
+	}
+
+	utxoSet := blockchain.UTXOSet{BlockChain: chain}
+	utxoSet.ResolvePending(wallets)
+	bestHeight := chain.GetBestHeight()
+	locked := wallets.LockedInputs()
+
+	results := []UnspentResult{}
+	for _, utxo := range utxoSet.FindUnspentFor(lookup) {
+		addr := hashes[hex.EncodeToString(utxo.Output.PubKeyHash)]
+		results = append(results, UnspentResult{
+			TxID:          hex.EncodeToString(utxo.TxID),
+			Vout:          utxo.Index,
+			Amount:        utxo.Output.Value,
+			Address:       addr,
+			Label:         wallets.Label(addr),
+			Confirmations: bestHeight - utxo.Height + 1,
+			WatchOnly:     wallets.GetAddress(addr).WatchOnly(),
+			Locked:        locked[utxo.Outpoint().String()],
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Confirmations != results[j].Confirmations {
+			return results[i].Confirmations > results[j].Confirmations
+		}
+		if results[i].TxID != results[j].TxID {
+			return results[i].TxID < results[j].TxID
+		}
+		return results[i].Vout < results[j].Vout
+	})
+	return results
+}
+
+// NewWalletBalance totals the wallet's unspent outputs and the unconfirmed
+// transactions in pool and in the wallet's pending list that pay to or spend
+// from it. Offline callers pass a nil pool.
+func NewWalletBalance(chain *blockchain.BlockChain, wallets *wallet.Wallets, pool []blockchain.Transaction) WalletBalanceResult {
+	utxos := NewUnspentResults(chain, wallets)
+	res := WalletBalanceResult{Pending: len(wallets.Pending)}
+
+	inPool := make(map[string]bool, len(pool))
+	for _, tx := range pool {
+		inPool[hex.EncodeToString(tx.ID)] = true
+	}
+	pool = append([]blockchain.Transaction{}, pool...)
+	for id, p := range wallets.Pending {
+		if !inPool[id] {
+			pool = append(pool, blockchain.DeserializeTransaction(p.Raw))
+		}
+	}
+
+	// Outputs the pool may spend, confirmed or not, keyed by outpoint.
+	owned := make(map[string]UnspentResult)
+	for _, utxo := range utxos {
+		owned[outpoint(utxo.TxID, utxo.Vout)] = utxo
+		switch {
+		case utxo.WatchOnly:
+			res.WatchOnlyConfirmed += utxo.Amount
+		case utxo.Locked:
+			res.Confirmed += utxo.Amount
+		default:
+			res.Confirmed += utxo.Amount
+			res.Available += utxo.Amount
+		}
+	}
+
+	hashes := walletHashes(wallets)
+	for _, tx := range pool {
+		for i, out := range tx.Outputs {
+			addr, ok := hashes[hex.EncodeToString(out.PubKeyHash)]
+			if !ok {
+				continue
+			}
+			watchOnly := wallets.GetAddress(addr).WatchOnly()
+			owned[outpoint(hex.EncodeToString(tx.ID), i)] = UnspentResult{Amount: out.Value, WatchOnly: watchOnly}
+			if watchOnly {
+				res.WatchOnlyUnconfirmed += out.Value
+			} else {
+				res.Unconfirmed += out.Value
+			}
+		}
+	}
+
+	for _, tx := range pool {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			spent, ok := owned[outpoint(hex.EncodeToString(in.ID), in.Out)]
+			if !ok {
+				continue
+			}
+			if spent.WatchOnly {
+				res.WatchOnlyUnconfirmed -= spent.Amount
+			} else {
+				res.Unconfirmed -= spent.Amount
+			}
+		}
+	}
+
+	return res
+}
+
+func getWalletBalance(s *Server, params []json.RawMessage) (any, *Error) {
+	wallets, err := wallet.CreateWallets(s.cfg.NodeID)
+	if err != nil {
+		return nil, errorf(ErrInternal, "%s", err)
+	}
+	return NewWalletBalance(s.chain, wallets, network.TxPool().Transactions()), nil
+}
+
+func listUnspent(s *Server, params []json.RawMessage) (any, *Error) {
+	wallets, err := wallet.CreateWallets(s.cfg.NodeID)
+	if err != nil {
+		return nil, errorf(ErrInternal, "%s", err)
+	}
+	return NewUnspentResults(s.chain, wallets), nil
+}
+
+// NewRawTransaction builds an unsigned transaction paying payments from an
+// address of wallets, which may be watch-only as long as its public key is
+// known. Change goes to change when set, back to a watch-only address, or to
+// a new change address of the wallet otherwise.
+func NewRawTransaction(chain *blockchain.BlockChain, wallets *wallet.Wallets, from string, payments []blockchain.Payment, change string, selector blockchain.CoinSelector) (*blockchain.RawTx, error) {
+	w := wallets.GetAddress(from)
+	if w == nil {
+		return nil, fmt.Errorf("address %s is not in the wallet", from)
+	}
+
+	derived := false
+	if change == "" && !w.WatchOnly() {
+		var err error
+		if change, err = wallets.ChangeAddress(); err != nil {
+			return nil, err
+		}
+		derived = true
+	}
+
+	utxoSet := blockchain.UTXOSet{BlockChain: chain}
+	utxoSet.ResolvePending(wallets)
+	tx, prevTxs, err := blockchain.NewUnsignedTransaction(w, payments, change, &utxoSet,
+		blockchain.SkipLocked(selector, wallets.LockedInputs()))
+	if err != nil {
+		return nil, err
+	}
+	if derived && len(tx.Outputs) == len(payments) {
+		wallets.ReleaseChange(change)
+	}
+
+	return blockchain.NewRawTx(tx, prevTxs), nil
+}
+
+// createRawTransaction takes from, a list of {address, amount} objects, and
+// optionally a change address, a coin selection strategy and inputs.
+func createRawTransaction(s *Server, params []json.RawMessage) (any, *Error) {
+	from, rpcErr := stringParam(params, 0, "from")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if len(params) < 2 {
+		return nil, errorf(ErrInvalidParams, "missing parameter payments")
+	}
+	var payments []blockchain.Payment
+	if err := json.Unmarshal(params[1], &payments); err != nil {
+		return nil, errorf(ErrInvalidParams, "payments must be an array of {address, amount} objects")
+	}
+	var change, strategy string
+	if len(params) > 2 {
+		if change, rpcErr = stringParam(params, 2, "change"); rpcErr != nil {
+			return nil, rpcErr
+		}
+	}
+	if len(params) > 3 {
+		if strategy, rpcErr = stringParam(params, 3, "strategy"); rpcErr != nil {
+			return nil, rpcErr
+		}
+	}
+	inputs, rpcErr := stringsParam(params, 4, "inputs")
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	if change != "" && !wallet.ValidateAddress(change) {
+		return nil, errorf(ErrInvalidParams, "invalid change address %s", change)
+	}
+	if _, err := blockchain.ValidatePayments(payments); err != nil {
+		return nil, errorf(ErrInvalidParams, "%s", err)
+	}
+	selector, rpcErr := coinSelector(strategy, inputs)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	var raw *blockchain.RawTx
+	err := wallet.Update(s.cfg.NodeID, func(wallets *wallet.Wallets) error {
+		var err error
+		raw, err = NewRawTransaction(s.chain, wallets, from, payments, change, selector)
+		return err
+	})
+	if err != nil {
+		return nil, walletError(err)
+	}
+	return raw, nil
+}
+
+// trackRawTransaction adds a transaction broadcast with sendrawtransaction to
+// the wallet's pending list when it spends from a wallet address, so its
+// inputs are locked like those of send.
+func (s *Server) trackRawTransaction(tx *blockchain.Transaction, height int) {
+	err := wallet.Update(s.cfg.NodeID, func(wallets *wallet.Wallets) error {
+		for _, in := range tx.Inputs {
+			if wallets.GetAddress(string(wallet.HashToAddress(wallet.PublicKeyHash(in.PubKey)))) != nil {
+				wallets.AddPending(hex.EncodeToString(tx.ID), blockchain.NewPendingTx(tx, height))
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		rpcLog.Error("could not track raw transaction", "txid", hex.EncodeToString(tx.ID), "err", err)
+	}
+}
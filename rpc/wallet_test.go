@@ -0,0 +1,93 @@
+package rpc_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/FG420/go-block/blockchain"
+	"github.com/FG420/go-block/rpc"
+	"github.com/FG420/go-block/wallet"
+)
+
+// spendTx spends output out of prev with the key of from. The balance never
+// checks signatures, so it stays unsigned.
+func spendTx(prev []byte, out int, from *wallet.Wallet, outputs ...*blockchain.TxOutput) blockchain.Transaction {
+	tx := blockchain.Transaction{Inputs: []blockchain.TxInput{{ID: prev, Out: out, PubKey: from.PublicKey}}}
+	for _, output := range outputs {
+		tx.Outputs = append(tx.Outputs, *output)
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func TestWalletBalance(t *testing.T) {
+	node := newNode(t)
+	node.chain.MineBlock([]*blockchain.Transaction{blockchain.CoinbaseTx(node.addr, "")})
+	utxoSet := blockchain.UTXOSet{BlockChain: node.chain}
+	utxoSet.Reindex()
+
+	ws, err := wallet.CreateWallets(node.nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch, err := ws.ImportWallet(watchOnly(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, other := ws.GetAddress(node.addr), string(wallet.MakeWallet().Address())
+
+	utxos := rpc.NewUnspentResults(node.chain, ws)
+	if len(utxos) != 2 || utxos[0].Confirmations != 2 || utxos[1].Confirmations != 1 {
+		t.Fatalf("unspent %+v", utxos)
+	}
+	genesis, _ := hex.DecodeString(utxos[0].TxID)
+	mined, _ := hex.DecodeString(utxos[1].TxID)
+
+	// Relayed and tracked: counted once.
+	sent := spendTx(genesis, 0, a, blockchain.NewTxOutput(30, b), blockchain.NewTxOutput(20, other),
+		blockchain.NewTxOutput(50, node.addr))
+	// Tracked only, as after a restart that lost the mempool.
+	tracked := spendTx(mined, 0, a, blockchain.NewTxOutput(50, other), blockchain.NewTxOutput(50, node.addr))
+	// In the mempool only, spending an unconfirmed output.
+	chained := spendTx(sent.ID, 0, ws.GetAddress(b), blockchain.NewTxOutput(10, other), blockchain.NewTxOutput(20, b))
+	// Someone else paying a watch-only address.
+	unknown := make([]byte, 32)
+	rand.Read(unknown)
+	incoming := spendTx(unknown, 0, wallet.MakeWallet(), blockchain.NewTxOutput(5, watch))
+
+	ws.AddPending(hex.EncodeToString(sent.ID), blockchain.NewPendingTx(&sent, 1))
+	ws.AddPending(hex.EncodeToString(tracked.ID), blockchain.NewPendingTx(&tracked, 1))
+	pool := []blockchain.Transaction{sent, chained, incoming}
+
+	got := rpc.NewWalletBalance(node.chain, ws, pool)
+	want := rpc.WalletBalanceResult{
+		Confirmed:            200,
+		Unconfirmed:          -80,
+		Available:            0,
+		Pending:              2,
+		WatchOnlyUnconfirmed: 5,
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// Offline, without the mempool, only tracked transactions count.
+	got = rpc.NewWalletBalance(node.chain, ws, nil)
+	want = rpc.WalletBalanceResult{Confirmed: 200, Unconfirmed: -70, Pending: 2}
+	if got != want {
+		t.Fatalf("offline: got %+v, want %+v", got, want)
+	}
+}
+
+func watchOnly(t *testing.T) *wallet.Wallet {
+	w, err := wallet.NewWatchOnlyPubKey(wallet.MakeWallet().PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
@@ -0,0 +1,334 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const keySize = 32
+
+// scrypt cost used for new wallets. The parameters are stored in the wallet
+// file, so they can be raised later without breaking existing wallets.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	ErrWatchOnly        = errors.New("address is watch-only and cannot sign")
+	ErrNotEncrypted     = errors.New("wallet is not encrypted")
+	ErrAlreadyEncrypted = errors.New("wallet is already encrypted")
+	ErrLocked           = errors.New("wallet is locked")
+	ErrWrongPassphrase  = errors.New("the wallet passphrase entered was incorrect")
+
+	// checkPlaintext is sealed with the wallet key so a passphrase can be
+	// verified even when the wallet holds no keys yet.
+	checkPlaintext = []byte("go-block wallet")
+)
+
+type (
+	// Encryption holds the parameters needed to derive the wallet key from
+	// the passphrase.
+	Encryption struct {
+		Salt  []byte
+		N     int
+		R     int
+		P     int
+		Check []byte
+	}
+
+	session struct {
+		key   []byte
+		timer *time.Timer
+	}
+)
+
+var (
+	sessionMu sync.Mutex
+	sessions  = make(map[string]*session)
+)
+
+func (e *Encryption) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), e.Salt, e.N, e.R, e.P, keySize)
+}
+
+// seal encrypts plaintext with AES-GCM, binding it to data. The nonce is
+// prepended to the ciphertext.
+func seal(key, plaintext, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, data), nil
+}
+
+func open(key, sealed, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, data)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// sealKey encrypts the private key of w, bound to its public key.
+func (w *Wallet) sealKey(key []byte) error {
+	d := w.PrivateKey.D.FillBytes(make([]byte, keySize))
+	defer zero(d)
+
+	sealed, err := seal(key, d, w.PublicKey)
+	if err != nil {
+		return err
+	}
+	w.EncryptedKey = sealed
+	return nil
+}
+
+func (w *Wallet) openKey(key []byte) error {
+	d, err := open(key, w.EncryptedKey, w.PublicKey)
+	if err != nil {
+		return err
+	}
+	defer zero(d)
+
+	w.PrivateKey.D = new(big.Int).SetBytes(d)
+	return nil
+}
+
+// sealSeed encrypts the HD seed, bound to the account key. The plaintext
+// stays in memory until the wallet is locked.
+func (hd *HDChain) sealSeed(key []byte) error {
+	sealed, err := seal(key, hd.Seed, []byte(hd.XPub))
+	if err != nil {
+		return err
+	}
+	hd.EncryptedSeed = sealed
+	return nil
+}
+
+func (hd *HDChain) openSeed(key []byte) error {
+	seed, err := open(key, hd.EncryptedSeed, []byte(hd.XPub))
+	if err != nil {
+		return err
+	}
+	hd.Seed = seed
+	return nil
+}
+
+// Locked reports whether the private key of w is unavailable for signing.
+func (w *Wallet) Locked() bool {
+	return w.PrivateKey == nil || w.PrivateKey.D == nil
+}
+
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.Encryption != nil
+}
+
+// Locked reports whether the wallet is encrypted and its keys are not
+// available.
+func (ws *Wallets) Locked() bool {
+	return ws.IsEncrypted() && ws.key == nil
+}
+
+// Encrypt seals every private key with a key derived from passphrase. The
+// wallet stays unlocked until Lock is called.
+func (ws *Wallets) Encrypt(passphrase string) error {
+	if ws.IsEncrypted() {
+		return ErrAlreadyEncrypted
+	}
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+
+	enc := &Encryption{Salt: make([]byte, 16), N: scryptN, R: scryptR, P: scryptP}
+	if _, err := io.ReadFull(rand.Reader, enc.Salt); err != nil {
+		return err
+	}
+	key, err := enc.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	if enc.Check, err = seal(key, checkPlaintext, enc.Salt); err != nil {
+		return err
+	}
+
+	for _, w := range ws.Wallets {
+		if w.WatchOnly() {
+			continue
+		}
+		if err := w.sealKey(key); err != nil {
+			return err
+		}
+	}
+	if ws.HD != nil {
+		if err := ws.HD.sealSeed(key); err != nil {
+			return err
+		}
+	}
+
+	ws.Encryption = enc
+	ws.key = key
+	walletLog.Info("wallet encrypted", "addresses", len(ws.Wallets))
+
+	return nil
+}
+
+// Unlock decrypts the private keys with the key derived from passphrase.
+func (ws *Wallets) Unlock(passphrase string) error {
+	if !ws.IsEncrypted() {
+		return ErrNotEncrypted
+	}
+
+	key, err := ws.Encryption.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	return ws.unlockWithKey(key)
+}
+
+func (ws *Wallets) unlockWithKey(key []byte) error {
+	if _, err := open(key, ws.Encryption.Check, ws.Encryption.Salt); err != nil {
+		return err
+	}
+
+	for _, w := range ws.Wallets {
+		if w.WatchOnly() {
+			continue
+		}
+		if err := w.openKey(key); err != nil {
+			ws.Lock()
+			return err
+		}
+	}
+	if ws.HD != nil {
+		if err := ws.HD.openSeed(key); err != nil {
+			ws.Lock()
+			return err
+		}
+	}
+
+	ws.key = key
+	return nil
+}
+
+// Lock drops the decrypted private keys from memory.
+func (ws *Wallets) Lock() {
+	if !ws.IsEncrypted() {
+		return
+	}
+
+	for _, w := range ws.Wallets {
+		if w.PrivateKey != nil && w.PrivateKey.D != nil {
+			w.PrivateKey.D.SetInt64(0)
+			w.PrivateKey.D = nil
+		}
+	}
+	if ws.HD != nil && ws.HD.Seed != nil {
+		zero(ws.HD.Seed)
+		ws.HD.Seed = nil
+	}
+	ws.key = nil
+}
+
+// UnlockFor verifies passphrase against the wallet file of nodeId and keeps
+// the derived key in memory for timeout, so wallets loaded by this process
+// in the meantime come up unlocked.
+func UnlockFor(nodeId, passphrase string, timeout time.Duration) error {
+	ws, err := CreateWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	if err := ws.Unlock(passphrase); err != nil {
+		return err
+	}
+	key := append([]byte{}, ws.key...)
+	ws.Lock()
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	if old, ok := sessions[nodeId]; ok {
+		old.timer.Stop()
+		zero(old.key)
+	}
+	s := &session{key: key}
+	s.timer = time.AfterFunc(timeout, func() { expire(nodeId, s) })
+	sessions[nodeId] = s
+	walletLog.Info("wallet unlocked", "node", nodeId, "timeout", timeout)
+
+	return nil
+}
+
+// LockFor forgets the key kept by UnlockFor.
+func LockFor(nodeId string) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	if s, ok := sessions[nodeId]; ok {
+		s.timer.Stop()
+		zero(s.key)
+		delete(sessions, nodeId)
+		walletLog.Info("wallet locked", "node", nodeId)
+	}
+}
+
+// expire ends session s when it times out. A timer that fired while
+// UnlockFor was replacing s must leave the new session alone.
+func expire(nodeId string, s *session) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	if sessions[nodeId] != s {
+		return
+	}
+	zero(s.key)
+	delete(sessions, nodeId)
+	walletLog.Info("wallet locked", "node", nodeId)
+}
+
+func sessionKey(nodeId string) []byte {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	if s, ok := sessions[nodeId]; ok {
+		return append([]byte{}, s.key...)
+	}
+	return nil
+}
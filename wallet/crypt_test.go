@@ -0,0 +1,65 @@
+package wallet_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/FG420/go-block/wallet"
+)
+
+// TestMain runs the tests in a scratch directory, as wallet files live
+// under ./tmp.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wallet-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.Mkdir("tmp", 0755); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func locked(t *testing.T, nodeId string) bool {
+	t.Helper()
+	ws, err := wallet.CreateWallets(nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws.Locked()
+}
+
+// The timeout of a replaced session doesn't lock the wallet again.
+func TestUnlockForStaleTimer(t *testing.T) {
+	const nodeId = "unlock"
+	ws, _ := newWallets(t, 1)
+	if err := ws.Encrypt("secret"); err != nil {
+		t.Fatal(err)
+	}
+	ws.SaveFile(nodeId)
+	defer wallet.LockFor(nodeId)
+
+	if err := wallet.UnlockFor(nodeId, "secret", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	stale := wallet.ExpireLate(nodeId)
+	if err := wallet.UnlockFor(nodeId, "secret", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	stale()
+	if locked(t, nodeId) {
+		t.Fatal("stale timer locked the new session")
+	}
+
+	wallet.ExpireLate(nodeId)()
+	if !locked(t, nodeId) {
+		t.Fatal("session outlived its timeout")
+	}
+}
@@ -0,0 +1,11 @@
+package wallet
+
+// ExpireLate returns the timeout of the current session of nodeId, to run as
+// a timer that fired just before the session was replaced would.
+func ExpireLate(nodeId string) func() {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	s := sessions[nodeId]
+	return func() { expire(nodeId, s) }
+}
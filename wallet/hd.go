@@ -0,0 +1,386 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// HardenedOffset is added to a child index to derive a hardened child, which
+// can only be derived from the private parent.
+const HardenedOffset uint32 = 1 << 31
+
+// Chains of the default account, m/0'/chain/index.
+const (
+	ReceiveChain uint32 = 0
+	ChangeChain  uint32 = 1
+)
+
+const (
+	seedSize       = 32
+	extendedKeyLen = 78
+)
+
+var (
+	masterHMACKey = []byte("go-block seed")
+
+	// Version bytes of serialized extended keys. They give the Base58 forms
+	// the familiar xprv and xpub prefixes.
+	privateVersion = []byte{0x04, 0x88, 0xad, 0xe4}
+	publicVersion  = []byte{0x04, 0x88, 0xb2, 0x1e}
+
+	ErrInvalidKey     = errors.New("invalid extended key")
+	ErrHardenedPublic = errors.New("cannot derive a hardened child from a public key")
+	ErrDerivation     = errors.New("derived key is invalid, use the next index")
+)
+
+type (
+	// ExtendedKey is a P-256 key with the chain code needed to derive its
+	// children.
+	ExtendedKey struct {
+		key       []byte // 32 byte scalar, or a 33 byte compressed point
+		chainCode []byte
+		depth     byte
+		parentFP  []byte
+		index     uint32
+		private   bool
+	}
+
+	// HDChain is the deterministic key source of a wallet. Seed is cleared
+	// once the wallet is encrypted and only EncryptedSeed is kept.
+	HDChain struct {
+		Seed          []byte `json:",omitempty"`
+		EncryptedSeed []byte `json:",omitempty"`
+		// XPub is the account key, so addresses can be derived and watched
+		// without the seed.
+		XPub        string
+		NextReceive uint32
+		NextChange  uint32
+	}
+)
+
+func curveOrder() *big.Int {
+	return elliptic.P256().Params().N
+}
+
+// NewMasterKey derives the root key of the tree from seed.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	mac := hmac.New(sha512.New, masterHMACKey)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	k := new(big.Int).SetBytes(sum[:32])
+	if k.Sign() == 0 || k.Cmp(curveOrder()) >= 0 {
+		return nil, ErrDerivation
+	}
+
+	return &ExtendedKey{
+		key:       sum[:32],
+		chainCode: sum[32:],
+		parentFP:  make([]byte, 4),
+		private:   true,
+	}, nil
+}
+
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.private
+}
+
+func (k *ExtendedKey) pubPoint() (*big.Int, *big.Int) {
+	if k.private {
+		return elliptic.P256().ScalarBaseMult(k.key)
+	}
+	return elliptic.UnmarshalCompressed(elliptic.P256(), k.key)
+}
+
+func (k *ExtendedKey) compressedPub() []byte {
+	if !k.private {
+		return k.key
+	}
+	x, y := k.pubPoint()
+	return elliptic.MarshalCompressed(elliptic.P256(), x, y)
+}
+
+func (k *ExtendedKey) fingerprint() []byte {
+	return PublicKeyHash(k.compressedPub())[:4]
+}
+
+// Child derives the child at index. Indexes from HardenedOffset on derive
+// hardened children.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	hardened := index >= HardenedOffset
+	if hardened && !k.private {
+		return nil, ErrHardenedPublic
+	}
+
+	var data []byte
+	if hardened {
+		data = append([]byte{0x00}, k.key...)
+	} else {
+		data = k.compressedPub()
+	}
+	data = binary.BigEndian.AppendUint32(append([]byte{}, data...), index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(curveOrder()) >= 0 {
+		return nil, ErrDerivation
+	}
+
+	child := &ExtendedKey{
+		chainCode: sum[32:],
+		depth:     k.depth + 1,
+		parentFP:  k.fingerprint(),
+		index:     index,
+		private:   k.private,
+	}
+
+	curve := elliptic.P256()
+	if k.private {
+		d := il.Add(il, new(big.Int).SetBytes(k.key))
+		d.Mod(d, curveOrder())
+		if d.Sign() == 0 {
+			return nil, ErrDerivation
+		}
+		child.key = d.FillBytes(make([]byte, 32))
+	} else {
+		ix, iy := curve.ScalarBaseMult(sum[:32])
+		px, py := k.pubPoint()
+		x, y := curve.Add(ix, iy, px, py)
+		if x.Sign() == 0 && y.Sign() == 0 {
+			return nil, ErrDerivation
+		}
+		child.key = elliptic.MarshalCompressed(curve, x, y)
+	}
+
+	return child, nil
+}
+
+// Derive follows a path such as m/0'/1/5 from k.
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) == 0 || (parts[0] != "m" && parts[0] != "M") {
+		return nil, fmt.Errorf("derivation path %q must start with m", path)
+	}
+
+	key := k
+	for _, part := range parts[1:] {
+		var offset uint32
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") {
+			part = part[:len(part)-1]
+			offset = HardenedOffset
+		}
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derivation path %q", path)
+		}
+		if key, err = key.Child(uint32(index) + offset); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// Neuter returns the public half of k.
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if !k.private {
+		return k
+	}
+
+	pub := *k
+	pub.key = k.compressedPub()
+	pub.private = false
+	return &pub
+}
+
+// PublicKey returns the key in the wallet's uncompressed X||Y form.
+func (k *ExtendedKey) PublicKey() []byte {
+	x, y := k.pubPoint()
+	return PublicKeyBytes(x, y)
+}
+
+func (k *ExtendedKey) ECPrivateKey() (*ecdsa.PrivateKey, error) {
+	if !k.private {
+		return nil, errors.New("extended key is public")
+	}
+
+	x, y := k.pubPoint()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+		D:         new(big.Int).SetBytes(k.key),
+	}, nil
+}
+
+// Wallet returns the wallet entry of k. Public keys give entries without a
+// private key.
+func (k *ExtendedKey) Wallet() *Wallet {
+	w := &Wallet{PublicKey: k.PublicKey()}
+	if priv, err := k.ECPrivateKey(); err == nil {
+		w.PrivateKey = priv
+	}
+	return w
+}
+
+// String serializes k in the Base58Check extended key format.
+func (k *ExtendedKey) String() string {
+	buf := make([]byte, 0, extendedKeyLen+checksumLength)
+	if k.private {
+		buf = append(buf, privateVersion...)
+	} else {
+		buf = append(buf, publicVersion...)
+	}
+	buf = append(buf, k.depth)
+	buf = append(buf, k.parentFP...)
+	buf = binary.BigEndian.AppendUint32(buf, k.index)
+	buf = append(buf, k.chainCode...)
+	if k.private {
+		buf = append(buf, 0x00)
+	}
+	buf = append(buf, k.key...)
+	buf = append(buf, Checksum(buf)...)
+
+	return string(Base58Encode(buf))
+}
+
+// ParseExtendedKey reads a key produced by ExtendedKey.String.
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	if !ValidateAddress(s) {
+		return nil, ErrInvalidKey
+	}
+	data := Base58Decode([]byte(s))
+	if len(data) != extendedKeyLen+checksumLength {
+		return nil, ErrInvalidKey
+	}
+	data = data[:extendedKeyLen]
+
+	k := &ExtendedKey{
+		depth:     data[4],
+		parentFP:  data[5:9],
+		index:     binary.BigEndian.Uint32(data[9:13]),
+		chainCode: data[13:45],
+	}
+	switch {
+	case bytes.Equal(data[:4], privateVersion) && data[45] == 0x00:
+		k.private = true
+		k.key = data[46:]
+		if d := new(big.Int).SetBytes(k.key); d.Sign() == 0 || d.Cmp(curveOrder()) >= 0 {
+			return nil, ErrInvalidKey
+		}
+	case bytes.Equal(data[:4], publicVersion):
+		k.key = data[45:]
+		if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), k.key); x == nil {
+			return nil, ErrInvalidKey
+		}
+	default:
+		return nil, ErrInvalidKey
+	}
+
+	return k, nil
+}
+
+// AccountPath is the path of the wallet's account key.
+func AccountPath() string {
+	return "m/0'"
+}
+
+func chainPath(chain, index uint32) string {
+	return fmt.Sprintf("%s/%d/%d", AccountPath(), chain, index)
+}
+
+// NewHDChain creates a chain from seed, or from a random seed when seed is
+// nil.
+func NewHDChain(seed []byte) (*HDChain, error) {
+	if seed == nil {
+		seed = make([]byte, seedSize)
+		if _, err := io.ReadFull(rand.Reader, seed); err != nil {
+			return nil, err
+		}
+	}
+
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	account, err := master.Derive(AccountPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return &HDChain{Seed: seed, XPub: account.Neuter().String()}, nil
+}
+
+// MarshalJSON leaves out the seed of an encrypted chain, which is only held
+// in memory while the wallet is unlocked.
+func (hd *HDChain) MarshalJSON() ([]byte, error) {
+	type plain HDChain
+	out := plain(*hd)
+	if out.EncryptedSeed != nil {
+		out.Seed = nil
+	}
+	return json.Marshal(out)
+}
+
+func (hd *HDChain) account() (*ExtendedKey, error) {
+	if hd.Seed == nil {
+		return nil, ErrLocked
+	}
+	master, err := NewMasterKey(hd.Seed)
+	if err != nil {
+		return nil, err
+	}
+	return master.Derive(AccountPath())
+}
+
+// counter returns the next index to derive on chain.
+func (hd *HDChain) counter(chain uint32) *uint32 {
+	if chain == ChangeChain {
+		return &hd.NextChange
+	}
+	return &hd.NextReceive
+}
+
+// next derives the next usable key of chain and advances its counter. Indexes
+// giving an invalid key are skipped.
+func (hd *HDChain) next(chain uint32) (*Wallet, error) {
+	account, err := hd.account()
+	if err != nil {
+		return nil, err
+	}
+	chainKey, err := account.Child(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := hd.counter(chain)
+	for {
+		index := *counter
+		*counter++
+
+		key, err := chainKey.Child(index)
+		if err == ErrDerivation {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		w := key.Wallet()
+		w.Path = chainPath(chain, index)
+		return w, nil
+	}
+}
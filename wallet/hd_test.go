@@ -0,0 +1,138 @@
+package wallet_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/FG420/go-block/wallet"
+)
+
+func TestPublicDerivationMatchesPrivate(t *testing.T) {
+	master, err := wallet.NewMasterKey(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := master.Derive("m/0'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xpub, err := wallet.ParseExtendedKey(account.Neuter().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xpub.IsPrivate() {
+		t.Fatal("parsed xpub is private")
+	}
+
+	for _, path := range []string{"m/0/0", "m/0/7", "m/1/3"} {
+		priv, err := account.Derive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := xpub.Derive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(priv.PublicKey(), pub.PublicKey()) {
+			t.Errorf("%s: public derivation differs from private derivation", path)
+		}
+	}
+
+	if _, err := xpub.Child(wallet.HardenedOffset); err != wallet.ErrHardenedPublic {
+		t.Errorf("hardened child of an xpub returned %v", err)
+	}
+
+	xprv, err := wallet.ParseExtendedKey(account.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xprv.String() != account.String() {
+		t.Error("xprv does not round trip")
+	}
+}
+
+func TestHDWalletSurvivesEncryption(t *testing.T) {
+	ws, addrs := newWallets(t, 1)
+	first := addrs[0]
+	seed := append([]byte{}, ws.HD.Seed...)
+
+	if err := ws.Encrypt("secret"); err != nil {
+		t.Fatal(err)
+	}
+	loaded := reload(t, ws)
+	if loaded.HD.Seed != nil {
+		t.Fatal("encrypted wallet file contains the seed")
+	}
+	if err := loaded.Unlock("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.HD.Seed, seed) {
+		t.Fatal("unlocked seed does not match")
+	}
+
+	second, err := loaded.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := loaded.ChangeAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || change == first || change == second {
+		t.Fatal("derived addresses repeat")
+	}
+	if got := loaded.GetAddress(second).Path; got != "m/0'/0/1" {
+		t.Errorf("second receive address has path %s", got)
+	}
+
+	xpub, err := wallet.ParseExtendedKey(loaded.HD.XPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watched, err := xpub.Derive("m/1/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(watched.Wallet().Address()) != change {
+		t.Error("xpub does not derive the change address")
+	}
+}
+
+// Wallets encrypted before HD support get their chain on the first derive,
+// while encrypted.
+func TestEncryptedWalletStartsHDChain(t *testing.T) {
+	ws, _ := newWallets(t, 0)
+	if _, err := ws.ImportWallet(wallet.MakeWallet()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.ChangeAddress(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := reload(t, ws)
+	if loaded.HD == nil || loaded.HD.Seed != nil {
+		t.Fatal("HD seed was not sealed")
+	}
+	if _, err := loaded.AddWallet(); err != wallet.ErrLocked {
+		t.Fatalf("locked wallet derived an address: %v", err)
+	}
+	if err := loaded.Unlock("secret"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := loaded.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || loaded.GetAddress(first).Locked() {
+		t.Fatal("derived address repeats or first key did not unlock")
+	}
+}
@@ -0,0 +1,216 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// DefaultGapLimit is how many unused addresses in a row a restore derives
+// before it stops looking for more.
+const DefaultGapLimit = 20
+
+const (
+	mnemonicRounds = 2048
+	wordBits       = 11
+)
+
+// english.txt is the BIP39 English word list.
+//
+//go:embed english.txt
+var englishWords string
+
+var (
+	wordList  = strings.Fields(englishWords)
+	wordIndex = make(map[string]int, len(wordList))
+
+	ErrMnemonicChecksum = errors.New("mnemonic checksum does not match")
+)
+
+func init() {
+	for i, word := range wordList {
+		wordIndex[word] = i
+	}
+}
+
+// NewMnemonic returns a phrase encoding entropyBits of random entropy, 128
+// bits giving 12 words and 256 bits 24.
+func NewMnemonic(entropyBits int) (string, error) {
+	if entropyBits < 128 || entropyBits > 256 || entropyBits%32 != 0 {
+		return "", fmt.Errorf("entropy must be 128 to 256 bits in steps of 32, got %d", entropyBits)
+	}
+
+	entropy := make([]byte, entropyBits/8)
+	if _, err := io.ReadFull(rand.Reader, entropy); err != nil {
+		return "", err
+	}
+	return EntropyToMnemonic(entropy)
+}
+
+// EntropyToMnemonic appends the checksum to entropy and splits the result
+// into 11 bit word indexes.
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	entropyBits := len(entropy) * 8
+	if entropyBits < 128 || entropyBits > 256 || entropyBits%32 != 0 {
+		return "", fmt.Errorf("invalid entropy length %d", len(entropy))
+	}
+	checksumBits := entropyBits / 32
+
+	hash := sha256.Sum256(entropy)
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, uint(checksumBits))
+	data.Or(data, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	count := (entropyBits + checksumBits) / wordBits
+	words := make([]string, count)
+	mask := big.NewInt(1<<wordBits - 1)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = wordList[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, wordBits)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes a phrase and verifies its checksum.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("mnemonic must have 12 to 24 words in steps of 3, got %d", len(words))
+	}
+
+	data := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex[strings.ToLower(word)]
+		if !ok {
+			return nil, fmt.Errorf("%q is not in the word list", word)
+		}
+		data.Lsh(data, wordBits)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) * wordBits / 33
+	entropyBits := len(words)*wordBits - checksumBits
+
+	checksum := new(big.Int).And(data, big.NewInt(1<<checksumBits-1)).Int64()
+	data.Rsh(data, uint(checksumBits))
+	entropy := data.FillBytes(make([]byte, entropyBits/8))
+
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return nil, ErrMnemonicChecksum
+	}
+
+	return entropy, nil
+}
+
+// MnemonicSeed stretches a phrase and an optional passphrase into the 64
+// byte seed of the HD chain. The English list is ASCII, so the phrase only
+// needs its whitespace and case normalized.
+func MnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := MnemonicToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+
+	phrase := strings.ToLower(strings.Join(strings.Fields(mnemonic), " "))
+	return pbkdf2.Key([]byte(phrase), []byte("mnemonic"+passphrase), mnemonicRounds, 64, sha512.New), nil
+}
+
+// RestoreWallets rebuilds a wallet from its mnemonic. Both chains are derived
+// until gap addresses in a row are unused, and every address up to the last
+// used one is added back. used reports whether a public key hash has ever
+// received coins.
+func RestoreWallets(mnemonic, passphrase string, gap int, used func(pubKeyHash []byte) bool) (*Wallets, error) {
+	if gap < 1 {
+		return nil, fmt.Errorf("gap limit must be positive, got %d", gap)
+	}
+
+	seed, err := MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	hd, err := NewHDChain(seed)
+	if err != nil {
+		return nil, err
+	}
+	account, err := hd.account()
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &Wallets{Wallets: make(map[string]*Wallet), HD: hd}
+	for _, chain := range []uint32{ReceiveChain, ChangeChain} {
+		found, next, err := scanChain(account, AccountPath(), chain, gap, 0, used)
+		if err != nil {
+			return nil, err
+		}
+		*hd.counter(chain) = next
+		for _, w := range found {
+			ws.Wallets[string(w.Address())] = w
+		}
+	}
+
+	// Keep a receive address even in an unused wallet.
+	if len(ws.Wallets) == 0 {
+		if _, err := ws.AddWallet(); err != nil {
+			return nil, err
+		}
+	}
+
+	walletLog.Info("restored wallet", "addresses", len(ws.Wallets), "receive", hd.NextReceive, "change", hd.NextChange)
+	return ws, nil
+}
+
+// scanChain derives the keys of chain below parent until gap keys in a row
+// are unused. It returns the keys up to the last used one plus extra unused
+// ones, and the index after the last used key.
+func scanChain(parent *ExtendedKey, parentPath string, chain uint32, gap, extra int, used func(pubKeyHash []byte) bool) ([]*Wallet, uint32, error) {
+	chainKey, err := parent.Child(chain)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var derived []*Wallet
+	var indexes []uint32
+	next := uint32(0)
+	for index, unused := uint32(0), 0; unused < gap; index++ {
+		key, err := chainKey.Child(index)
+		if err == ErrDerivation {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		w := key.Wallet()
+		w.Path = fmt.Sprintf("%s/%d/%d", parentPath, chain, index)
+		derived = append(derived, w)
+		indexes = append(indexes, index)
+
+		if used(w.KeyHash()) {
+			next = index + 1
+			unused = 0
+		} else {
+			unused++
+		}
+	}
+
+	var found []*Wallet
+	for i, w := range derived {
+		if indexes[i] < next {
+			found = append(found, w)
+		} else if extra > 0 {
+			found = append(found, w)
+			extra--
+		}
+	}
+	return found, next, nil
+}
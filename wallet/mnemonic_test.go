@@ -0,0 +1,83 @@
+package wallet_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/FG420/go-block/wallet"
+)
+
+func TestMnemonicVector(t *testing.T) {
+	phrase, err := wallet.EntropyToMnemonic(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Repeat("abandon ", 11) + "about"
+	if phrase != want {
+		t.Fatalf("got %q, want %q", phrase, want)
+	}
+
+	seed, err := wallet.MnemonicSeed(phrase, "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantSeed = "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+	if hex.EncodeToString(seed) != wantSeed {
+		t.Errorf("seed mismatch: %x", seed)
+	}
+
+	if _, err := wallet.MnemonicToEntropy(strings.Repeat("abandon ", 12)); err != wallet.ErrMnemonicChecksum {
+		t.Errorf("bad checksum returned %v", err)
+	}
+}
+
+func TestRestoreScansToGapLimit(t *testing.T) {
+	phrase, err := wallet.NewMnemonic(128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := wallet.MnemonicSeed(phrase, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, _ := newWallets(t, 0)
+	if err := original.SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	used := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		addr, err := original.AddWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 || i == 3 {
+			used[addr] = true
+		}
+	}
+	change, err := original.ChangeAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	used[change] = true
+
+	restored, err := wallet.RestoreWallets(phrase, "", 3, func(pubKeyHash []byte) bool {
+		return used[string(wallet.HashToAddress(pubKeyHash))]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored.Wallets) != 5 {
+		t.Errorf("restored %d addresses, want 5", len(restored.Wallets))
+	}
+	for addr := range original.Wallets {
+		if restored.GetAddress(addr) == nil {
+			t.Errorf("address %s was not restored", addr)
+		}
+	}
+	if restored.HD.NextReceive != 4 || restored.HD.NextChange != 1 {
+		t.Errorf("next indexes are %d/%d, want 4/1", restored.HD.NextReceive, restored.HD.NextChange)
+	}
+}
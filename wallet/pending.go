@@ -0,0 +1,105 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/FG420/go-block/handlers"
+)
+
+const (
+	lockDir = "./tmp/wallets_%s.lock"
+	// lockWait is how long Update waits for another process, such as the
+	// CLI while a node runs, to finish with the wallet file.
+	lockWait = 5 * time.Second
+)
+
+// PendingTx is an outgoing transaction that has not been confirmed yet. Its
+// inputs stay locked so later sends don't spend them again.
+type PendingTx struct {
+	// Raw is the serialized transaction.
+	Raw []byte
+	// Inputs lists the spent outputs as TXID:VOUT.
+	Inputs []string
+	// Height is the best height when the transaction was sent, and
+	// Broadcast the best height it was last broadcast at.
+	Height    int
+	Broadcast int
+}
+
+// fileMu serializes Update, so the node's background work and RPC calls
+// don't overwrite each other's changes to the wallet file. Other processes
+// are kept out by the lockfile taken with lockFile.
+var fileMu sync.Mutex
+
+// Update loads the wallet of nodeId, applies fn and saves the wallet unless
+// fn fails. Every change to the wallet file should go through it.
+func Update(nodeId string, fn func(ws *Wallets) error) error {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	unlock, err := lockFile(nodeId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ws, err := CreateWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	if err := fn(ws); err != nil {
+		return err
+	}
+	ws.SaveFile(nodeId)
+	return nil
+}
+
+// lockFile claims the wallet file of nodeId against other processes and
+// returns the function releasing it.
+func lockFile(nodeId string) (func(), error) {
+	dir := fmt.Sprintf(lockDir, nodeId)
+	deadline := time.Now().Add(lockWait)
+	for {
+		err := handlers.AcquireLock(dir)
+		if err == nil {
+			return func() { handlers.ReleaseLock(dir) }, nil
+		}
+		var locked *handlers.LockedError
+		if !errors.As(err, &locked) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("wallet of node %s is in use by pid %d", nodeId, locked.PID)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// AddPending starts tracking the transaction txID.
+func (ws *Wallets) AddPending(txID string, tx *PendingTx) {
+	if ws.Pending == nil {
+		ws.Pending = make(map[string]*PendingTx)
+	}
+	ws.Pending[txID] = tx
+	walletLog.Info("tracking unconfirmed transaction", "txid", txID, "inputs", len(tx.Inputs))
+}
+
+// RemovePending stops tracking txID, which unlocks its inputs.
+func (ws *Wallets) RemovePending(txID string) {
+	delete(ws.Pending, txID)
+}
+
+// LockedInputs returns the outputs spent by pending transactions, as
+// TXID:VOUT.
+func (ws *Wallets) LockedInputs() map[string]bool {
+	locked := make(map[string]bool)
+	for _, tx := range ws.Pending {
+		for _, in := range tx.Inputs {
+			locked[in] = true
+		}
+	}
+	return locked
+}
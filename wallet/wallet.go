@@ -7,46 +7,121 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math/big"
 
 	"github.com/FG420/go-block/handlers"
+	"github.com/mr-tron/base58"
 )
 
 const (
 	checksumLength = 4
 	version        = byte(0x00)
+	privKeyVersion = byte(0x80)
 )
 
+var ErrInvalidPrivateKey = errors.New("invalid private key")
+
 type Wallet struct {
 	PrivateKey *ecdsa.PrivateKey
 	PublicKey  []byte
+	// EncryptedKey is the sealed private key of an encrypted wallet. D is
+	// only set while the wallet is unlocked and is never written out then.
+	EncryptedKey []byte
+	// Path is the derivation path of keys that come from the wallet's HD
+	// chain.
+	Path string
+	// PubKeyHash identifies watch-only entries that were added by address,
+	// without a public key.
+	PubKeyHash []byte
+}
+
+// NewWatchOnly returns a wallet entry that tracks addr without being able to
+// spend from it.
+func NewWatchOnly(addr string) (*Wallet, error) {
+	if !ValidateAddress(addr) {
+		return nil, fmt.Errorf("address %s is not valid", addr)
+	}
+	return &Wallet{PubKeyHash: AddressToHash(addr)}, nil
+}
+
+// NewWatchOnlyPubKey returns a watch-only entry for a public key in the
+// wallet's X||Y form.
+func NewWatchOnlyPubKey(pubKey []byte) (*Wallet, error) {
+	if len(pubKey) != 64 {
+		return nil, fmt.Errorf("public key must be 64 bytes, got %d", len(pubKey))
+	}
+	x := new(big.Int).SetBytes(pubKey[:32])
+	y := new(big.Int).SetBytes(pubKey[32:])
+	if !elliptic.P256().IsOnCurve(x, y) {
+		return nil, errors.New("public key is not on the curve")
+	}
+	return &Wallet{PublicKey: pubKey}, nil
+}
+
+// WatchOnly reports whether w has no private key, sealed or not.
+func (w *Wallet) WatchOnly() bool {
+	return w.PrivateKey == nil && w.EncryptedKey == nil
+}
+
+// KeyHash returns the public key hash that outputs to w are locked with.
+func (w *Wallet) KeyHash() []byte {
+	if w.PublicKey == nil {
+		return w.PubKeyHash
+	}
+	return PublicKeyHash(w.PublicKey)
 }
 
 func (w *Wallet) Address() []byte {
-	pubHash := PublicKeyHash(w.PublicKey)
+	pubHash := w.KeyHash()
+
+	// fmt.Printf("address: %x\n", addr)
+
+	return HashToAddress(pubHash)
+}
+
+func HashToAddress(pubHash []byte) []byte {
 	versionedHash := append([]byte{version}, pubHash...)
 	checksum := Checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
-	addr := Base58Encode(fullHash)
-
-	// fmt.Printf("address: %x\n", addr)
+	return Base58Encode(fullHash)
+}
 
-	return addr
+func AddressToHash(addr string) []byte {
+	pubKeyHash := Base58Decode([]byte(addr))
+	return pubKeyHash[1 : len(pubKeyHash)-checksumLength]
 }
 
 func (w *Wallet) MarshalJSON() ([]byte, error) {
 	mapStringAny := map[string]any{
-		"PrivateKey": map[string]any{
-			"D": w.PrivateKey.D,
-			"PublicKey": map[string]any{
-				"X": w.PrivateKey.PublicKey.X,
-				"Y": w.PrivateKey.PublicKey.Y,
-			},
-			"Curve": w.PrivateKey.PublicKey.Curve.Params(),
-		},
 		"PublicKey": w.PublicKey,
 	}
+	if w.Path != "" {
+		mapStringAny["Path"] = w.Path
+	}
+	if w.PubKeyHash != nil {
+		mapStringAny["PubKeyHash"] = w.PubKeyHash
+	}
+	if w.PrivateKey == nil {
+		return json.Marshal(mapStringAny)
+	}
+
+	privateKey := map[string]any{
+		"PublicKey": map[string]any{
+			"X": w.PrivateKey.PublicKey.X,
+			"Y": w.PrivateKey.PublicKey.Y,
+		},
+		"Curve": w.PrivateKey.PublicKey.Curve.Params(),
+	}
+	mapStringAny["PrivateKey"] = privateKey
+
+	if w.EncryptedKey != nil {
+		mapStringAny["EncryptedKey"] = w.EncryptedKey
+	} else {
+		privateKey["D"] = w.PrivateKey.D
+	}
 	return json.Marshal(mapStringAny)
 }
 
@@ -60,7 +135,10 @@ func (w *Wallet) UnmarshalJSON(data []byte) error {
 				Curve elliptic.Curve `json:"Curve"`
 			} `json:"PublicKey"`
 		} `json:"PrivateKey"`
-		PublicKey []byte `json:"PublicKey"`
+		PublicKey    []byte `json:"PublicKey"`
+		EncryptedKey []byte `json:"EncryptedKey"`
+		Path         string `json:"Path"`
+		PubKeyHash   []byte `json:"PubKeyHash"`
 	}
 
 	if err := json.Unmarshal(data, &aux); err != nil {
@@ -68,7 +146,13 @@ func (w *Wallet) UnmarshalJSON(data []byte) error {
 	}
 
 	w.PublicKey = aux.PublicKey
+	w.EncryptedKey = aux.EncryptedKey
+	w.Path = aux.Path
+	w.PubKeyHash = aux.PubKeyHash
 
+	if aux.PrivateKey.D == nil && aux.EncryptedKey == nil {
+		return nil
+	}
 	w.PrivateKey = &ecdsa.PrivateKey{
 		D: aux.PrivateKey.D,
 		PublicKey: ecdsa.PublicKey{
@@ -82,7 +166,10 @@ func (w *Wallet) UnmarshalJSON(data []byte) error {
 }
 
 func ValidateAddress(addr string) bool {
-	pubKeyHash := Base58Decode([]byte(addr))
+	pubKeyHash, err := base58.Decode(addr)
+	if err != nil || len(pubKeyHash) <= checksumLength+1 {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
 
 	version := pubKeyHash[0]
@@ -99,9 +186,16 @@ func NewPair() (*ecdsa.PrivateKey, []byte, error) {
 		return nil, nil, err
 	}
 
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	return private, PublicKeyBytes(private.PublicKey.X, private.PublicKey.Y), nil
+}
 
-	return private, pub, nil
+// PublicKeyBytes encodes a point as X||Y, each padded to 32 bytes so the
+// halves can be split again on verification.
+func PublicKeyBytes(x, y *big.Int) []byte {
+	pub := make([]byte, 64)
+	x.FillBytes(pub[:32])
+	y.FillBytes(pub[32:])
+	return pub
 }
 
 func MakeWallet() *Wallet {
@@ -129,3 +223,45 @@ func Checksum(payload []byte) []byte {
 
 	return secondHash[:checksumLength]
 }
+
+// EncodePrivateKey serializes the private key of w as Base58Check with the
+// private key version byte, the same way addresses are encoded.
+func (w *Wallet) EncodePrivateKey() (string, error) {
+	if w.WatchOnly() {
+		return "", ErrWatchOnly
+	}
+	if w.Locked() {
+		return "", ErrLocked
+	}
+
+	payload := append([]byte{privKeyVersion}, w.PrivateKey.D.FillBytes(make([]byte, keySize))...)
+	payload = append(payload, Checksum(payload)...)
+	return string(Base58Encode(payload)), nil
+}
+
+// DecodePrivateKey reads a key written by EncodePrivateKey into a wallet
+// entry.
+func DecodePrivateKey(encoded string) (*Wallet, error) {
+	if !ValidateAddress(encoded) {
+		return nil, ErrInvalidPrivateKey
+	}
+	payload := Base58Decode([]byte(encoded))
+	if len(payload) != 1+keySize+checksumLength || payload[0] != privKeyVersion {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	d := new(big.Int).SetBytes(payload[1 : 1+keySize])
+	if d.Sign() == 0 || d.Cmp(curveOrder()) >= 0 {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult(payload[1 : 1+keySize])
+	return &Wallet{
+		PrivateKey: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+			D:         d,
+		},
+		PublicKey: PublicKeyBytes(x, y),
+	}, nil
+}
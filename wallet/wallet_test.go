@@ -1,14 +1,194 @@
 package wallet_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"log"
+	"math/big"
 	"testing"
 
 	"github.com/FG420/go-block/wallet"
 )
 
+// newWallets returns an in-memory wallet with n addresses from a fresh seed.
+func newWallets(t *testing.T, n int) (*wallet.Wallets, []string) {
+	t.Helper()
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet)}
+	var addrs []string
+	for i := 0; i < n; i++ {
+		addr, err := ws.AddWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return ws, addrs
+}
+
+// reload passes ws through the JSON of the wallet file.
+func reload(t *testing.T, ws *wallet.Wallets) *wallet.Wallets {
+	t.Helper()
+	data, err := json.Marshal(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded wallet.Wallets
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	return &loaded
+}
+
 func TestWallet(t *testing.T) {
 	wallet := wallet.MakeWallet()
 
 	log.Println(wallet)
 }
+
+func TestWalletEncryption(t *testing.T) {
+	ws, addrs := newWallets(t, 1)
+	addr := addrs[0]
+	d := new(big.Int).Set(ws.GetAddress(addr).PrivateKey.D)
+
+	if err := ws.Encrypt("secret"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(d.String())) {
+		t.Fatal("encrypted wallet file contains the plaintext key")
+	}
+
+	loaded := reload(t, ws)
+	if !loaded.Locked() || !loaded.GetAddress(addr).Locked() {
+		t.Fatal("loaded wallet should be locked")
+	}
+	if _, err := loaded.AddWallet(); err != wallet.ErrLocked {
+		t.Errorf("AddWallet on a locked wallet returned %v, want ErrLocked", err)
+	}
+	if err := loaded.Unlock("wrong"); err != wallet.ErrWrongPassphrase {
+		t.Errorf("Unlock with a wrong passphrase returned %v", err)
+	}
+	if err := loaded.Unlock("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GetAddress(addr).PrivateKey.D.Cmp(d) != 0 {
+		t.Error("unlocked key does not match the original")
+	}
+
+	loaded.Lock()
+	if !loaded.GetAddress(addr).Locked() {
+		t.Error("wallet still holds its key after Lock")
+	}
+}
+
+func TestPrivateKeyRoundTrip(t *testing.T) {
+	w := wallet.MakeWallet()
+	encoded, err := w.EncodePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := wallet.DecodePrivateKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Address(), w.Address()) {
+		t.Error("decoded key gives a different address")
+	}
+
+	if _, err := wallet.DecodePrivateKey(string(w.Address())); err != wallet.ErrInvalidPrivateKey {
+		t.Errorf("decoding an address returned %v", err)
+	}
+}
+
+func TestWatchOnlyEntries(t *testing.T) {
+	cold := wallet.MakeWallet()
+	byAddr, err := wallet.NewWatchOnly(string(cold.Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey, err := wallet.NewWatchOnlyPubKey(wallet.MakeWallet().PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws, _ := newWallets(t, 1)
+	for _, w := range []*wallet.Wallet{byAddr, byKey} {
+		if _, err := ws.ImportWallet(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ws.Encrypt("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := reload(t, ws)
+	if err := loaded.Unlock("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	watched := loaded.GetAddress(string(cold.Address()))
+	if watched == nil || !watched.WatchOnly() {
+		t.Fatal("watch-only address did not survive a reload")
+	}
+	if _, err := watched.EncodePrivateKey(); err != wallet.ErrWatchOnly {
+		t.Errorf("exporting a watch-only key returned %v", err)
+	}
+	if !loaded.GetAddress(string(byKey.Address())).WatchOnly() {
+		t.Error("watch-only public key lost its flag")
+	}
+}
+
+func TestAddressLabels(t *testing.T) {
+	ws, addrs := newWallets(t, 1)
+	addr := addrs[0]
+	if err := ws.SetLabel(addr, "savings"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := reload(t, ws)
+	if got := loaded.Label(addr); got != "savings" {
+		t.Fatalf("label after reload is %q", got)
+	}
+
+	if err := loaded.SetLabel(addr, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Label(addr); got != "" {
+		t.Errorf("cleared label is %q", got)
+	}
+	if err := loaded.SetLabel("1BoatSLRHtKNngkdXEeobR76b53LETtpyT", "x"); err == nil {
+		t.Error("labelled an address outside the wallet")
+	}
+}
+
+func TestPendingLocksInputs(t *testing.T) {
+	ws, _ := newWallets(t, 0)
+	ws.AddPending("aa", &wallet.PendingTx{Inputs: []string{"01:0", "02:1"}})
+	ws.AddPending("bb", &wallet.PendingTx{Inputs: []string{"03:0"}})
+
+	locked := ws.LockedInputs()
+	if len(locked) != 3 || !locked["02:1"] {
+		t.Fatalf("locked inputs are %v", locked)
+	}
+	ws.RemovePending("aa")
+	if locked := ws.LockedInputs(); locked["01:0"] || !locked["03:0"] {
+		t.Errorf("after removal locked inputs are %v", locked)
+	}
+
+	first, err := ws.ChangeAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.ReleaseChange(first)
+	again, err := ws.ChangeAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first || ws.HD.NextChange != 1 {
+		t.Errorf("released change address was not reused")
+	}
+}
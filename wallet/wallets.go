@@ -2,26 +2,50 @@ package wallet
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+
+	"github.com/FG420/go-block/logging"
 )
 
 const walletFile = "./tmp/wallets_%s.json"
 
+var walletLog = logging.Get(logging.Wallet)
+
 // const walletFile = "./tmp/wallets_%s.data"
 
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets    map[string]*Wallet
+	Encryption *Encryption `json:",omitempty"`
+	HD         *HDChain    `json:",omitempty"`
+	// Labels names addresses of the wallet for listings.
+	Labels map[string]string `json:",omitempty"`
+	// Pending holds the outgoing unconfirmed transactions by ID.
+	Pending map[string]*PendingTx `json:",omitempty"`
+
+	// key is the derived wallet key while an encrypted wallet is unlocked.
+	key []byte
 }
 
+// CreateWallets loads the wallet of nodeId. An encrypted wallet comes up
+// unlocked while UnlockFor holds its key.
 func CreateWallets(nodeId string) (*Wallets, error) {
 	var wallets Wallets
 
 	wallets.Wallets = make(map[string]*Wallet)
-	err := wallets.LoadFile(nodeId)
+	if err := wallets.LoadFile(nodeId); err != nil {
+		return &wallets, err
+	}
 
-	return &wallets, err
+	if key := sessionKey(nodeId); key != nil && wallets.IsEncrypted() {
+		if err := wallets.unlockWithKey(key); err != nil {
+			return &wallets, err
+		}
+	}
+
+	return &wallets, nil
 }
 
 func (ws *Wallets) GetAddress(addr string) *Wallet {
@@ -38,12 +62,166 @@ func (ws *Wallets) GetAllAddresses() []string {
 	return addrs
 }
 
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+// Label returns the label of addr, or "" when it has none.
+func (ws *Wallets) Label(addr string) string {
+	return ws.Labels[addr]
+}
+
+// SetLabel names an address of the wallet. An empty label removes it.
+func (ws *Wallets) SetLabel(addr, label string) error {
+	if ws.GetAddress(addr) == nil {
+		return fmt.Errorf("address %s is not in the wallet", addr)
+	}
+
+	if label == "" {
+		delete(ws.Labels, addr)
+		return nil
+	}
+	if ws.Labels == nil {
+		ws.Labels = make(map[string]string)
+	}
+	ws.Labels[addr] = label
+	return nil
+}
+
+// SetSeed starts the wallet's HD chain from seed, e.g. one made from a
+// mnemonic. A wallet keeps the chain it was given first.
+func (ws *Wallets) SetSeed(seed []byte) error {
+	if ws.HD != nil {
+		return errors.New("wallet already has an HD seed")
+	}
+	if ws.Locked() {
+		return ErrLocked
+	}
+
+	hd, err := NewHDChain(seed)
+	if err != nil {
+		return err
+	}
+	if ws.IsEncrypted() {
+		if err := hd.sealSeed(ws.key); err != nil {
+			return err
+		}
+	}
+
+	ws.HD = hd
+	return nil
+}
+
+// ImportWallet adds a key that does not come from the HD chain, such as one
+// read by DecodePrivateKey.
+func (ws *Wallets) ImportWallet(w *Wallet) (string, error) {
+	if ws.Locked() && !w.WatchOnly() {
+		return "", ErrLocked
+	}
+
+	addr := fmt.Sprintf("%s", w.Address())
+	if _, ok := ws.Wallets[addr]; ok {
+		return addr, fmt.Errorf("address %s is already in the wallet", addr)
+	}
+
+	if ws.IsEncrypted() && !w.WatchOnly() {
+		if err := w.sealKey(ws.key); err != nil {
+			return "", err
+		}
+	}
+
+	ws.Wallets[addr] = w
+	walletLog.Info("imported address", "addr", addr, "watchonly", w.WatchOnly())
+	return addr, nil
+}
+
+// WatchXPub adds the used addresses of both chains below an extended public
+// key as watch-only entries, plus the next gap unused receive addresses so
+// incoming payments are seen. It returns the addresses that were added.
+func (ws *Wallets) WatchXPub(xpub string, gap int, used func(pubKeyHash []byte) bool) ([]string, error) {
+	key, err := ParseExtendedKey(xpub)
+	if err != nil {
+		return nil, err
+	}
+	key = key.Neuter()
+
+	var added []string
+	for _, chain := range []uint32{ReceiveChain, ChangeChain} {
+		extra := 0
+		if chain == ReceiveChain {
+			extra = gap
+		}
+		found, _, err := scanChain(key, "xpub", chain, gap, extra, used)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, w := range found {
+			addr := string(w.Address())
+			if _, ok := ws.Wallets[addr]; ok {
+				continue
+			}
+			ws.Wallets[addr] = w
+			added = append(added, addr)
+		}
+	}
+
+	walletLog.Info("watching extended key", "addresses", len(added))
+	return added, nil
+}
+
+// AddWallet derives the next receive address of the HD chain. Wallets made
+// before HD support get a chain the first time they add an address.
+func (ws *Wallets) AddWallet() (string, error) {
+	return ws.derive(ReceiveChain)
+}
+
+// ChangeAddress derives the next address of the change chain.
+func (ws *Wallets) ChangeAddress() (string, error) {
+	return ws.derive(ChangeChain)
+}
+
+// ReleaseChange gives back a change address that ended up unused, so the
+// next transaction derives it again. Only the latest change address can be
+// released.
+func (ws *Wallets) ReleaseChange(addr string) {
+	w := ws.GetAddress(addr)
+	if w == nil || ws.HD == nil || ws.HD.NextChange == 0 || w.Path != chainPath(ChangeChain, ws.HD.NextChange-1) {
+		return
+	}
+	delete(ws.Wallets, addr)
+	ws.HD.NextChange--
+}
+
+func (ws *Wallets) derive(chain uint32) (string, error) {
+	if ws.Locked() {
+		return "", ErrLocked
+	}
+
+	if ws.HD == nil {
+		hd, err := NewHDChain(nil)
+		if err != nil {
+			return "", err
+		}
+		if ws.IsEncrypted() {
+			if err := hd.sealSeed(ws.key); err != nil {
+				return "", err
+			}
+		}
+		ws.HD = hd
+	}
+
+	wallet, err := ws.HD.next(chain)
+	if err != nil {
+		return "", err
+	}
 	addr := fmt.Sprintf("%s", wallet.Address())
 
+	if ws.IsEncrypted() {
+		if err := wallet.sealKey(ws.key); err != nil {
+			return "", err
+		}
+	}
+
 	ws.Wallets[addr] = wallet
-	return addr
+	walletLog.Info("created address", "addr", addr, "path", wallet.Path)
+	return addr, nil
 }
 
 // Json Save & Load File
@@ -59,7 +237,11 @@ func (ws *Wallets) LoadFile(nodeId string) error {
 	}
 
 	var temp struct {
-		Wallets map[string]*Wallet `json:"Wallets"`
+		Wallets    map[string]*Wallet    `json:"Wallets"`
+		Encryption *Encryption           `json:"Encryption"`
+		HD         *HDChain              `json:"HD"`
+		Labels     map[string]string     `json:"Labels"`
+		Pending    map[string]*PendingTx `json:"Pending"`
 	}
 
 	err = json.Unmarshal(fileContent, &temp)
@@ -68,6 +250,11 @@ func (ws *Wallets) LoadFile(nodeId string) error {
 	}
 
 	ws.Wallets = temp.Wallets
+	ws.Encryption = temp.Encryption
+	ws.HD = temp.HD
+	ws.Labels = temp.Labels
+	ws.Pending = temp.Pending
+	walletLog.Debug("loaded wallet file", "path", walletFile, "addresses", len(ws.Wallets))
 
 	return nil
 }
@@ -79,10 +266,18 @@ func (ws Wallets) SaveFile(nodeId string) {
 		log.Panic(err)
 	}
 
-	err = os.WriteFile(walletFile, jsonData, 0666)
+	// Write a private copy and swap it in, so a crash never leaves a
+	// half-written wallet behind.
+	tmpFile := walletFile + ".tmp"
+	err = os.WriteFile(tmpFile, jsonData, 0600)
+	if err != nil {
+		log.Panic(err)
+	}
+	err = os.Rename(tmpFile, walletFile)
 	if err != nil {
 		log.Panic(err)
 	}
+	walletLog.Debug("saved wallet file", "path", walletFile, "addresses", len(ws.Wallets))
 }
 
 // Bog Save & Load File